@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/go-co-op/gocron"
 	twapConfig "github.com/gopartyparrot/goparrot-twap/config"
+	"github.com/gopartyparrot/goparrot-twap/notify"
 	"github.com/gopartyparrot/goparrot-twap/swap"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
@@ -19,36 +22,89 @@ import (
 )
 
 type CliArgs struct {
-	RPCUrl            string        `arg:"required,env" help:"rpc url"`
-	RPCWs             string        `arg:"required,env" help:"rpc websocket"`
-	WalletPK          string        `arg:"required,env,--wallet" help:"wallet private key"`
-	StorePath         string        `arg:"env" help:"store successful swaps logs" default:"./logs/swaps.json"`
-	Interval          string        `arg:"required,--interval" help:"run interval in time units (s, m, h)"`
-	Pair              string        `arg:"required,--pair" help:"pair"`
-	Side              swap.SwapSide `arg:"--side" help:"side of the swap can be buy or sell (default buy)" default:"buy"`
-	Amount            float64       `arg:"required,--amount" help:"amount to buy or sell"`
-	StopAmount        float64       `arg:"--stopAmount" help:"amount ro reach" default:"999999999999999"`
-	TransferAddress   string        `arg:"--transferAddress" help:"address to transfer the balance when above the TransferThreshold"`
-	TransferThreshold float64       `arg:"--transferThreshold" help:"threshold for transfer all balance to TransferAddress"`
-	PriceThreshold    float32       `arg:"--priceThreshold" help:"threshold for buy or sell depending on token price"`
+	RPCUrl                string                `arg:"required,env" help:"rpc url"`
+	RPCWs                 string                `arg:"required,env" help:"rpc websocket"`
+	WalletPK              string                `arg:"required,env,--wallet" help:"wallet private key"`
+	NotifyWebhook         string                `arg:"env" help:"webhook url (slack compatible) for alerts"`
+	StorePath             string                `arg:"env" help:"store successful swaps logs" default:"./logs/swaps.json"`
+	Tasks                 string                `arg:"--tasks" help:"JSON file with the tasks to run, instead of the task options"`
+	Interval              string                `arg:"--interval" help:"run interval in time units (s, m, h)"`
+	Mode                  swap.TaskMode         `arg:"--mode" help:"twap to buy or sell every interval, rebalance to keep rebalanceTarget of the wallet value in the pair token, grid to buy and sell on price levels" default:"twap"`
+	Pair                  string                `arg:"--pair" help:"pair"`
+	Side                  swap.SwapSide         `arg:"--side" help:"side of the swap can be buy or sell (default buy)" default:"buy"`
+	Amount                float64               `arg:"--amount" help:"amount to buy or sell"`
+	StopAmount            float64               `arg:"--stopAmount" help:"amount ro reach" default:"999999999999999"`
+	TransferAddress       string                `arg:"--transferAddress" help:"address to transfer the balance when above the TransferThreshold"`
+	TransferThreshold     float64               `arg:"--transferThreshold" help:"threshold for transfer all balance to TransferAddress"`
+	TransferCreateAccount bool                  `arg:"--transferCreateAccount" help:"create the token account of transferAddress when missing, paid by the wallet"`
+	TransferPolicy        swap.TransferPolicy   `arg:"--transferPolicy" help:"how much to transfer once above transferThreshold: all, excess (above transferRetain) or chunk (transferChunk at a time)" default:"all"`
+	TransferRetain        float64               `arg:"--transferRetain" help:"balance kept in the wallet with the excess transfer policy"`
+	TransferChunk         float64               `arg:"--transferChunk" help:"amount transferred at a time with the chunk transfer policy"`
+	TransferSplits        []string              `arg:"--transferSplit,separate" help:"address:weight to split the transfers between addresses instead of transferAddress, repeat for each address"`
+	TransferSweepAt       string                `arg:"--transferSweepAt" help:"also transfer every day at this UTC time (HH:MM), whatever the transferThreshold"`
+	CleanupReturnAddress  string                `arg:"--cleanupReturnAddress" help:"when the task completes, send the token left to sell to this address"`
+	CleanupCloseAccounts  bool                  `arg:"--cleanupCloseAccounts" help:"when the task completes, unwrap the WSOL of the wallet and close the emptied token accounts of the task"`
+	PriceThreshold        float64               `arg:"--priceThreshold" help:"threshold for buy or sell depending on token price"`
+	PriceSource           swap.PriceSource      `arg:"--priceSource" help:"price used by priceThreshold, pool (in quote token of the pair) or coingecko (in USD)" default:"coingecko"`
+	PriceReference        float64               `arg:"--priceReference" help:"reference price to scale the amount, buy more below it and sell more above it"`
+	PriceBand             float64               `arg:"--priceBand" help:"percent distance from priceReference at which the full amount is swapped"`
+	PriceMaxMultiplier    float64               `arg:"--priceMaxMultiplier" help:"max multiplier of the amount when using priceReference (default 2)"`
+	PriceCeiling          float64               `arg:"--priceCeiling" help:"never swap when the price is above"`
+	PriceFloor            float64               `arg:"--priceFloor" help:"never swap when the price is below"`
+	Notional              float64               `arg:"--notional" help:"amount to buy or sell in notionalCurrency, converted to the token sold with the priceSource at every swap"`
+	NotionalCurrency      string                `arg:"--notionalCurrency" help:"currency of notional for the coingecko price source" default:"usd"`
+	BalancePercent        float64               `arg:"--balancePercent" help:"swap this percent of the current balance of the token sold at every interval"`
+	BalanceMinAmount      float64               `arg:"--balanceMinAmount" help:"minimum amount to swap when using balancePercent"`
+	BalanceFloor          float64               `arg:"--balanceFloor" help:"balance of the token sold that is never swapped"`
+	RebalanceTarget       float64               `arg:"--rebalanceTarget" help:"percent of the wallet value to keep in the pair token, the rest in the quote token"`
+	RebalanceTolerance    float64               `arg:"--rebalanceTolerance" help:"percent of drift from rebalanceTarget allowed before swapping"`
+	GridLower             float64               `arg:"--gridLower" help:"lowest price of the grid"`
+	GridUpper             float64               `arg:"--gridUpper" help:"highest price of the grid"`
+	GridLevels            int                   `arg:"--gridLevels" help:"number of price levels of the grid"`
+	TrailingStop          float64               `arg:"--trailingStop" help:"start selling when the pool price drops this percent below its highest value"`
+	TakeProfit            float64               `arg:"--takeProfit" help:"start selling when the pool price reaches this price"`
+	ExitAmount            float64               `arg:"--exitAmount" help:"total amount to sell once trailingStop or takeProfit is triggered"`
+	AuctionStartPrice     float64               `arg:"--auctionStartPrice" help:"dutch auction sell, first minimum price accepted (in quote token)"`
+	AuctionFloorPrice     float64               `arg:"--auctionFloorPrice" help:"dutch auction sell, lowest minimum price accepted"`
+	AuctionStep           float64               `arg:"--auctionStep" help:"dutch auction sell, price decrease at every auctionStepInterval"`
+	AuctionStepInterval   string                `arg:"--auctionStepInterval" help:"dutch auction sell, time between price decreases (s, m, h)"`
+	MaxVolatility         float64               `arg:"--maxVolatility" help:"pause trading while the hourly realized volatility of the pool price, in percent, is above"`
+	VolatilityWindow      string                `arg:"--volatilityWindow" help:"time window of pool prices used for the volatility (s, m, h)" default:"1h"`
+	DailyBudget           float64               `arg:"--dailyBudget" help:"max amount of the token sold to swap per UTC day"`
+	TotalBudget           float64               `arg:"--totalBudget" help:"max amount of the token sold to swap in total, then stop"`
+	SolReserve            float64               `arg:"--solReserve" help:"SOL kept in the wallet for fees, on top of the rent and fees of a swap" default:"0.01"`
+	VolumeProfile         string                `arg:"--volumeProfile" help:"JSON file with 24 hour-of-day (UTC) weights to scale each swap amount"`
+	VolumeSnapshots       bool                  `arg:"--volumeSnapshots" help:"record pool swap volume snapshots and build the volume profile from them, instead of volumeProfile"`
+	PovPercent            float64               `arg:"--povPercent" help:"size each swap as this percent of the pool volume since the last swap, capped by amount"`
+	PovMinAmount          float64               `arg:"--povMinAmount" help:"minimum amount to swap when using povPercent"`
+	DepthPercent          float64               `arg:"--depthPercent" help:"cap each swap to this percent of the pool reserve of the token sold, the rest is carried to next swaps"`
+	MissedTicks           swap.MissedTickPolicy `arg:"--missedTicks" help:"what to do with intervals missed while a swap was running or the process was down: skip, coalesce or catchup" default:"skip"`
+	MaxMissedTicks        int                   `arg:"--maxMissedTicks" help:"max number of missed intervals made up by coalesce or catchup" default:"10"`
+	SwapRetries           int                   `arg:"--swapRetries" help:"times a swap failing with a retryable error (rpc timeout, blockhash expired, slippage) is quoted and sent again in the interval, -1 for none" default:"2"`
+	RetryBackoff          string                `arg:"--retryBackoff" help:"wait before the first retry, doubled at each retry (s, m, h)" default:"2s"`
+	BreakerFailures       int                   `arg:"--breakerFailures" help:"pause the task after this many failed swaps in a row"`
+	BreakerFees           uint64                `arg:"--breakerFees" help:"pause the task once failed swaps have cost this many lamports of fees"`
+	BreakerCooldown       string                `arg:"--breakerCooldown" help:"try a swap again this long after the breaker tripped, it resumes the task on success (s, m, h). Without it only a reset resumes the task"`
+	ResetBreaker          bool                  `arg:"--resetBreaker" help:"reset the circuit breaker of the tasks on start, send SIGHUP to reset it while running"`
+	ResetFailed           bool                  `arg:"--resetFailed" help:"run again the tasks that failed before, they stay failed otherwise"`
 }
 
-func run() error {
+func run() (int, error) {
 	err := godotenv.Load()
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return fmt.Errorf("loading environment: %w", err)
+			return swap.ExitCode_Failed, fmt.Errorf("loading environment: %w", err)
 		}
 	}
 
 	var args CliArgs
-	arg.MustParse(&args)
+	parser := arg.MustParse(&args)
 
 	config := zap.NewDevelopmentConfig()
 	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	logger, err := config.Build()
 	if err != nil {
-		return fmt.Errorf("can't initialize zap logger: %w", err)
+		return swap.ExitCode_Failed, fmt.Errorf("can't initialize zap logger: %w", err)
 	}
 	defer logger.Sync()
 	logger.Info("using RPC",
@@ -59,7 +115,92 @@ func run() error {
 
 	clientRPC := rpc.New(args.RPCUrl)
 
-	swapper, err := swap.NewTokenSwapper(swap.TokenSwapperConfig{
+	var notifier notify.Notifier
+	if args.NotifyWebhook != "" {
+		notifier = notify.NewWebhook(args.NotifyWebhook, nil)
+	}
+
+	tasks := []swap.SwapTaskConfig{}
+	if args.Tasks != "" {
+		tasks, err = swap.LoadTasks(args.Tasks)
+		if err != nil {
+			logger.Fatal("load tasks", zap.Error(err))
+			return swap.ExitCode_Failed, err
+		}
+	} else {
+		if args.Pair == "" || args.Interval == "" {
+			parser.Fail("--pair and --interval are required without --tasks")
+		}
+		splits := []swap.TransferSplit{}
+		for _, value := range args.TransferSplits {
+			split, err := swap.ParseTransferSplit(value)
+			if err != nil {
+				parser.Fail(err.Error())
+			}
+			splits = append(splits, split)
+		}
+		tasks = append(tasks, swap.SwapTaskConfig{
+			Interval:               args.Interval,
+			Mode:                   args.Mode,
+			Pair:                   args.Pair,
+			Side:                   args.Side,
+			Amount:                 args.Amount,
+			StopAmount:             args.StopAmount,
+			TransferAddress:        args.TransferAddress,
+			TransferThreshold:      args.TransferThreshold,
+			TransferCreateAccount:  args.TransferCreateAccount,
+			TransferPolicy:         args.TransferPolicy,
+			TransferRetain:         args.TransferRetain,
+			TransferChunk:          args.TransferChunk,
+			TransferSplits:         splits,
+			TransferSweepAt:        args.TransferSweepAt,
+			CleanupReturnAddress:   args.CleanupReturnAddress,
+			CleanupCloseAccounts:   args.CleanupCloseAccounts,
+			PriceThreshold:         args.PriceThreshold,
+			PriceSource:            args.PriceSource,
+			PriceReference:         args.PriceReference,
+			PriceBandPercent:       args.PriceBand,
+			PriceMaxMultiplier:     args.PriceMaxMultiplier,
+			PriceCeiling:           args.PriceCeiling,
+			PriceFloor:             args.PriceFloor,
+			Notional:               args.Notional,
+			NotionalCurrency:       args.NotionalCurrency,
+			BalancePercent:         args.BalancePercent,
+			BalanceMinAmount:       args.BalanceMinAmount,
+			BalanceFloor:           args.BalanceFloor,
+			RebalanceTarget:        args.RebalanceTarget,
+			RebalanceTolerance:     args.RebalanceTolerance,
+			GridLower:              args.GridLower,
+			GridUpper:              args.GridUpper,
+			GridLevels:             args.GridLevels,
+			TrailingStopPercent:    args.TrailingStop,
+			TakeProfitPrice:        args.TakeProfit,
+			ExitAmount:             args.ExitAmount,
+			AuctionStartPrice:      args.AuctionStartPrice,
+			AuctionFloorPrice:      args.AuctionFloorPrice,
+			AuctionStep:            args.AuctionStep,
+			AuctionStepInterval:    args.AuctionStepInterval,
+			MaxVolatility:          args.MaxVolatility,
+			VolatilityWindow:       args.VolatilityWindow,
+			DailyBudget:            args.DailyBudget,
+			TotalBudget:            args.TotalBudget,
+			SolReserve:             args.SolReserve,
+			VolumeProfilePath:      args.VolumeProfile,
+			VolumeProfileSnapshots: args.VolumeSnapshots,
+			PovPercent:             args.PovPercent,
+			PovMinAmount:           args.PovMinAmount,
+			DepthPercent:           args.DepthPercent,
+			MissedTickPolicy:       args.MissedTicks,
+			MaxMissedTicks:         args.MaxMissedTicks,
+			SwapRetries:            args.SwapRetries,
+			RetryBackoff:           args.RetryBackoff,
+			BreakerFailures:        args.BreakerFailures,
+			BreakerFees:            args.BreakerFees,
+			BreakerCooldown:        args.BreakerCooldown,
+		})
+	}
+
+	wallet, err := swap.NewWallet(swap.TokenSwapperConfig{
 		ClientRPC:  clientRPC,
 		RPCWs:      args.RPCWs,
 		PrivateKey: args.WalletPK,
@@ -67,37 +208,92 @@ func run() error {
 		Logger:     logger,
 		Tokens:     twapConfig.GetTokens(),
 		Pools:      twapConfig.GetPools(),
+		Notifier:   notifier,
+
+		ResetFailed: args.ResetFailed,
 	})
 	if err != nil {
-		logger.Fatal("create swapper", zap.Error(err))
-		return err
+		logger.Fatal("create wallet", zap.Error(err))
+		return swap.ExitCode_Failed, err
 	}
 
-	err = swapper.Init(
-		context.Background(),
-		args.Pair,
-		args.Side,
-		args.Amount,
-		args.StopAmount,
-		args.TransferAddress,
-		args.TransferThreshold,
-		args.PriceThreshold,
-	)
-	if err != nil {
-		logger.Fatal("init swapper", zap.Error(err))
-		return err
+	swappers := []*swap.TokenSwapper{}
+	for _, task := range tasks {
+		swapper := wallet.NewTokenSwapper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+		err = swapper.Init(ctx, task)
+		cancel()
+		if err != nil {
+			logger.Fatal("init swapper", zap.String("task", task.ID), zap.Error(err))
+			return swap.ExitCode_Failed, err
+		}
+		if args.ResetBreaker {
+			swapper.ResetBreaker()
+		}
+		job := s.Every(task.Interval)
+		if at := swapper.ResumeAt(time.Now()); !at.IsZero() {
+			logger.Info("resuming task after interval", zap.String("task", swapper.ID()), zap.Time("at", at))
+			job = job.StartAt(at)
+		}
+		_, err = job.Do(swapper.Run)
+		if err != nil {
+			return swap.ExitCode_Failed, err
+		}
+		if task.TransferSweepAt != "" {
+			_, err = s.Every(1).Day().At(task.TransferSweepAt).Do(swapper.Sweep)
+			if err != nil {
+				return swap.ExitCode_Failed, err
+			}
+		}
+		swappers = append(swappers, swapper)
 	}
 
-	s.Every(args.Interval).Do(swapper.Start)
+	s.StartAsync()
 
-	s.StartBlocking()
+	resets := make(chan os.Signal, 1)
+	signal.Notify(resets, syscall.SIGHUP)
+	go func() {
+		for range resets {
+			logger.Info("circuit breakers reset at next run")
+			for _, swapper := range swappers {
+				swapper.ResetBreaker()
+			}
+		}
+	}()
 
-	return nil
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	for _, swapper := range swappers {
+		select {
+		case <-swapper.Done():
+		case <-signals:
+			for _, swapper := range swappers {
+				swapper.Stop()
+			}
+		}
+	}
+	s.Stop()
+	// Let the last runs and the end of task cleanups finish
+	for _, swapper := range swappers {
+		swapper.Wait()
+	}
+
+	// Exit with the worst final state of the tasks
+	code := swap.ExitCode_Completed
+	for _, swapper := range swappers {
+		state := swapper.State()
+		logger.Info("task ended", zap.String("task", swapper.ID()), zap.String("state", string(state)))
+		if state == swap.TaskState_Failed || (state == swap.TaskState_Stopped && code == swap.ExitCode_Completed) {
+			code = state.ExitCode()
+		}
+	}
+	return code, nil
 }
 
 func main() {
-	err := run()
+	code, err := run()
 	if err != nil {
 		log.Fatalln("run error %w", err)
 	}
+	os.Exit(code)
 }
@@ -22,8 +22,9 @@ func GetTokens() map[string]TokenInfo {
 }
 
 type TokenInfo struct {
-	Symbol   string
-	Decimals uint8
+	Symbol      string
+	Decimals    uint8
+	CoinGeckoID string
 }
 
 func (s *TokenInfo) Pow() float64 {
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Notifier sends alerts to the people running the Twap
+type Notifier interface {
+	Notify(message string) error
+}
+
+// Webhook posts messages as {"text": "..."} JSON, the format of Slack,
+// Mattermost and Discord (slack compatible) incoming webhooks
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhook create new webhook notifier
+func NewWebhook(url string, httpClient *http.Client) *Webhook {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Webhook{url: url, httpClient: httpClient}
+}
+
+// Notify post message to the webhook
+func (w *Webhook) Notify(message string) error {
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	resp, err := w.httpClient.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook status %d: %s", resp.StatusCode, data)
+	}
+	return nil
+}
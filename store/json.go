@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -62,6 +64,21 @@ func (s *JSONStore) Get(key string, val interface{}) (bool, error) {
 	return true, nil
 }
 
+// Keys returns the sorted keys starting with prefix.
+func (s *JSONStore) Keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for k := range s.kv {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (s *JSONStore) Set(key string, val interface{}) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,71 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidAuction = errors.New("auction needs the sell side, auctionStartPrice > auctionFloorPrice > 0, an auctionStep and an auctionStepInterval")
+)
+
+// AuctionState is saved in the store so the auction price keeps going down
+// from where it was after a restart.
+type AuctionState struct {
+	StartTime int64
+}
+
+func auctionKey(taskID string) string {
+	return fmt.Sprintf("auction_%s", taskID)
+}
+
+// AuctionPrice returns the minimum price accepted at now: it starts at
+// AuctionStartPrice and goes down by AuctionStep every AuctionStepInterval
+// until AuctionFloorPrice.
+func (s *TokenSwapper) AuctionPrice(now time.Time) (float64, error) {
+	var state AuctionState
+	found, err := s.store.Get(auctionKey(s.swapTask.ID), &state)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		state.StartTime = now.Unix()
+		err = s.store.Set(auctionKey(s.swapTask.ID), state)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	elapsed := now.Sub(time.Unix(state.StartTime, 0))
+	steps := int64(elapsed / s.swapTask.auctionStepInterval)
+	price := s.swapTask.AuctionStartPrice - float64(steps)*s.swapTask.AuctionStep
+	if price < s.swapTask.AuctionFloorPrice {
+		price = s.swapTask.AuctionFloorPrice
+	}
+	return price, nil
+}
+
+// AuctionAccepts tells if the quote minimum output price, in quote token per
+// pair token, meets the current auction price.
+func (s *TokenSwapper) AuctionAccepts(quote *SwapQuote, status *SwapStatus) (bool, error) {
+	auctionPrice, err := s.AuctionPrice(time.Now())
+	if err != nil {
+		return false, err
+	}
+	status.AuctionPrice = auctionPrice
+
+	fromTokenInfo := s.tokens[s.swapTask.fromToken]
+	toTokenInfo := s.tokens[s.swapTask.toToken]
+	quotePrice := toTokenInfo.ToFloat(quote.MinimumOutAmount) / fromTokenInfo.ToFloat(quote.InAmount)
+	if quotePrice < auctionPrice {
+		s.logger.Info("quote below auction price, no sell",
+			zap.Float64("quotePrice", quotePrice),
+			zap.Float64("auctionPrice", auctionPrice),
+		)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,52 @@
+package swap
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidPriceBand = errors.New("price band percent must be greater than zero when a price reference is set")
+)
+
+const defaultPriceMaxMultiplier = 2
+
+// needPrice tells if the task has any setting depending on the current price.
+func (t *SwapTaskConfig) needPrice() bool {
+	return t.PriceThreshold > 0 ||
+		t.PriceReference > 0 ||
+		t.PriceCeiling > 0 ||
+		t.PriceFloor > 0
+}
+
+// inPriceLimits checks the price against the hard ceiling and floor.
+func (t *SwapTaskConfig) inPriceLimits(price float64) bool {
+	if t.PriceCeiling > 0 && price > t.PriceCeiling {
+		return false
+	}
+	if t.PriceFloor > 0 && price < t.PriceFloor {
+		return false
+	}
+	return true
+}
+
+// PriceMultiplier scales the swap amount with the distance of the price from
+// PriceReference. Buys grow linearly as the price goes below the reference:
+// nothing at the reference, 1x at PriceBandPercent below it, up to
+// PriceMaxMultiplier. Sells mirror that above the reference.
+func (t *SwapTaskConfig) PriceMultiplier(price float64) float64 {
+	if t.PriceReference <= 0 {
+		return 1
+	}
+	distance := (t.PriceReference - price) / t.PriceReference
+	if t.Side == SwapSide_Sell {
+		distance = -distance
+	}
+	if distance <= 0 {
+		return 0
+	}
+	multiplier := distance * 100 / t.PriceBandPercent
+	if multiplier > t.PriceMaxMultiplier {
+		multiplier = t.PriceMaxMultiplier
+	}
+	return multiplier
+}
@@ -0,0 +1,116 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrCircuitOpen            = errors.New("circuit breaker tripped, swaps are paused")
+	ErrInvalidBreakerCooldown = errors.New("breaker cool-down must be a duration (s, m, h)")
+)
+
+func (t *SwapTaskConfig) hasBreaker() bool {
+	return t.BreakerFailures > 0 || t.BreakerFees > 0
+}
+
+// ResetBreaker closes the circuit breaker of the task at its next run.
+func (s *TokenSwapper) ResetBreaker() {
+	atomic.StoreInt32(&s.breakerReset, 1)
+}
+
+// checkBreaker returns ErrCircuitOpen while the breaker is tripped. Once the
+// cool-down has passed the slice goes through as a probe.
+func (s *TokenSwapper) checkBreaker(now time.Time) error {
+	if atomic.CompareAndSwapInt32(&s.breakerReset, 1, 0) {
+		s.closeBreaker("manual reset")
+	}
+	if !s.progress.BreakerTripped {
+		return nil
+	}
+	trippedAt := time.Unix(s.progress.BreakerTrippedAt, 0)
+	if s.swapTask.breakerCooldown > 0 && now.Sub(trippedAt) >= s.swapTask.breakerCooldown {
+		s.logger.Info("circuit breaker cool-down over, probing with a swap")
+		return nil
+	}
+	return ErrCircuitOpen
+}
+
+// updateBreaker counts the failed slices and the fees they cost, and trips
+// the breaker over the task limits. A probe swap closes it on success.
+func (s *TokenSwapper) updateBreaker(status *SwapStatus, err error) {
+	if !s.swapTask.hasBreaker() {
+		return
+	}
+	if err == nil {
+		if s.progress.BreakerTripped {
+			s.closeBreaker("probe swap succeeded")
+			return
+		}
+		s.progress.ConsecutiveFailures = 0
+		return
+	}
+
+	s.progress.ConsecutiveFailures++
+	s.progress.FailedFees += s.failedFees(status)
+	if s.progress.BreakerTripped {
+		s.logger.Warn("circuit breaker probe failed, waiting for another cool-down")
+		s.progress.BreakerTrippedAt = time.Now().Unix()
+		return
+	}
+
+	failures := s.swapTask.BreakerFailures > 0 && s.progress.ConsecutiveFailures >= s.swapTask.BreakerFailures
+	fees := s.swapTask.BreakerFees > 0 && s.progress.FailedFees >= s.swapTask.BreakerFees
+	if !failures && !fees {
+		return
+	}
+	s.progress.BreakerTripped = true
+	s.progress.BreakerTrippedAt = time.Now().Unix()
+	s.notify("circuit breaker tripped, task paused",
+		zap.Int("consecutiveFailures", s.progress.ConsecutiveFailures),
+		zap.Uint64("failedFees", s.progress.FailedFees),
+		zap.String("cooldown", s.swapTask.BreakerCooldown),
+		zap.NamedError("reason", err),
+	)
+}
+
+func (s *TokenSwapper) closeBreaker(reason string) {
+	if s.progress.BreakerTripped {
+		s.notify("circuit breaker reset, task resumed", zap.String("reason", reason))
+	}
+	s.progress.BreakerTripped = false
+	s.progress.BreakerTrippedAt = 0
+	s.progress.ConsecutiveFailures = 0
+	s.progress.FailedFees = 0
+}
+
+// failedFees returns the lamports paid by the failed transactions of the
+// slice, the ones that did not land cost nothing.
+func (s *TokenSwapper) failedFees(status *SwapStatus) uint64 {
+	// The context of the swap may be done already, it is often why it failed
+	ctx, cancel := context.WithTimeout(context.Background(), sentCheckTimeout)
+	defer cancel()
+	fees := uint64(0)
+	for _, attempt := range status.Attempts {
+		if attempt.TxID == "" || attempt.Error == "" {
+			continue
+		}
+		tx, err := s.clientRPC.GetTransaction(ctx, solana.MustSignatureFromBase58(attempt.TxID), &rpc.GetTransactionOpts{
+			Encoding: solana.EncodingBase64,
+		})
+		if err != nil {
+			s.logger.Warn("fail to get failed transaction fee", zap.String("txID", attempt.TxID), zap.Error(err))
+			continue
+		}
+		if tx != nil && tx.Meta != nil {
+			fees += tx.Meta.Fee
+		}
+	}
+	return fees
+}
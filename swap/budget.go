@@ -0,0 +1,94 @@
+package swap
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrTotalBudgetReached = errors.New("total budget spent")
+	ErrDailyBudgetReached = errors.New("daily budget spent")
+)
+
+// Spent returns the from token amount of the successful swaps of the task
+// recorded in the store, in total and during the UTC day of now. Unconfirmed
+// swaps are counted as they may still land.
+func (s *TokenSwapper) Spent(now time.Time) (uint64, uint64, error) {
+	dayStart := now.UTC().Truncate(24 * time.Hour)
+	var total, today uint64
+	for _, key := range s.store.Keys(s.swapTask.ID + "_") {
+		var status SwapStatus
+		_, err := s.store.Get(key, &status)
+		if err != nil {
+			return 0, 0, err
+		}
+		// Swaps saved before task ids are keyed by pair, the default task id
+		task := status.Task
+		if task == "" {
+			task = status.Pair
+		}
+		failed := status.ErrLogs != "" && !status.Unconfirmed
+		if task != s.swapTask.ID || status.TxID == "" || failed || status.Side != s.swapTask.Side {
+			continue
+		}
+		total += status.Amount
+		date, err := time.Parse(time.UnixDate, status.Date)
+		if err == nil && !date.Before(dayStart) {
+			today += status.Amount
+		}
+	}
+	return total, today, nil
+}
+
+// CapToBudget limits amount to what is left of the daily and total budgets.
+// It returns ErrTotalBudgetReached once the total budget is spent, and
+// ErrDailyBudgetReached while the daily budget is spent until the next UTC day.
+func (s *TokenSwapper) CapToBudget(fromTokenInfo config.TokenInfo, amount uint64) (uint64, error) {
+	return s.capToBudget(fromTokenInfo, amount, 1)
+}
+
+// hasBudget tells if the task swaps of side count in its budgets.
+func (s *TokenSwapper) hasBudget(side SwapSide) bool {
+	return side == s.swapTask.Side && (s.swapTask.DailyBudget > 0 || s.swapTask.TotalBudget > 0)
+}
+
+// capToBudget is CapToBudget for swaps of at least minAmount, a budget is
+// spent once less than minAmount is left.
+func (s *TokenSwapper) capToBudget(fromTokenInfo config.TokenInfo, amount uint64, minAmount uint64) (uint64, error) {
+	total, today, err := s.Spent(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if s.swapTask.TotalBudget > 0 {
+		budget := fromTokenInfo.FromFloat(s.swapTask.TotalBudget)
+		if total+minAmount > budget {
+			s.logger.Info("total budget spent, stopping swap",
+				zap.Uint64("totalBudget", budget),
+				zap.Uint64("spent", total),
+			)
+			return 0, ErrTotalBudgetReached
+		}
+		if amount > budget-total {
+			amount = budget - total
+		}
+	}
+
+	if s.swapTask.DailyBudget > 0 {
+		budget := fromTokenInfo.FromFloat(s.swapTask.DailyBudget)
+		if today+minAmount > budget {
+			s.logger.Info("daily budget spent, waiting for next day",
+				zap.Uint64("dailyBudget", budget),
+				zap.Uint64("spentToday", today),
+			)
+			return 0, ErrDailyBudgetReached
+		}
+		if amount > budget-today {
+			amount = budget - today
+		}
+	}
+	return amount, nil
+}
@@ -0,0 +1,180 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/token"
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidSweepTime = errors.New("transfer sweep time must be HH:MM (UTC)")
+)
+
+// Sweep transfers the to token balance to the transfer destinations, with
+// the transfer policy but whatever the transfer threshold. This is the job
+// given to the scheduler at TransferSweepAt every day.
+func (s *TokenSwapper) Sweep() {
+	if s.State().Ended() {
+		return
+	}
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer cancel()
+
+	err := s.UpdateBalances(ctx)
+	if err != nil {
+		s.logger.Warn("fail to update balances, skipping sweep", zap.Error(err))
+		return
+	}
+	balance := s.balance(s.tokenAccounts[s.swapTask.toToken])
+	err = s.transferOut(ctx, balance, "sweep")
+	if err != nil {
+		s.notify("sweep transfer failed", zap.Error(err))
+	}
+}
+
+// Cleanup runs once the task has completed: it sends the from token left to
+// CleanupReturnAddress, and with CleanupCloseAccounts unwraps the WSOL of
+// the wallet and closes the emptied token accounts of the task.
+func (s *TokenSwapper) Cleanup() {
+	if s.swapTask.CleanupReturnAddress == "" && !s.swapTask.CleanupCloseAccounts {
+		return
+	}
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer cancel()
+
+	if s.swapTask.CleanupReturnAddress != "" {
+		err := s.returnFromToken(ctx)
+		if err != nil {
+			s.notify("fail to return the from token at task end", zap.Error(err))
+		}
+	}
+	if s.swapTask.CleanupCloseAccounts {
+		err := s.closeEmptyAccounts(ctx)
+		if err != nil {
+			s.notify("fail to close token accounts at task end", zap.Error(err))
+		}
+	}
+}
+
+// returnFromToken sends the from token balance to the return address. The
+// balance is shared by the tasks of the wallet, so nothing is returned while
+// another running task uses the account.
+func (s *TokenSwapper) returnFromToken(ctx context.Context) error {
+	fromToken := s.swapTask.fromToken
+	fromAddress := s.tokenAccounts[fromToken]
+	if s.accountInUse(fromAddress) {
+		s.logger.Info("from token account used by another task, nothing returned", zap.String("account", fromAddress.String()))
+		return nil
+	}
+	err := s.UpdateBalances(ctx)
+	if err != nil {
+		return err
+	}
+	amount := s.balance(fromAddress)
+	if fromToken == config.NativeSOL {
+		reserve, err := s.FeeReserve(ctx)
+		if err != nil {
+			return err
+		}
+		if amount <= reserve {
+			return nil
+		}
+		amount -= reserve
+	}
+	if amount == 0 {
+		return nil
+	}
+
+	dest := s.swapTask.cleanupReturnAccount
+	s.logger.Info("returning "+s.tokens[fromToken].Symbol+" left",
+		zap.Uint64("amount", amount),
+		zap.String("returnAddress", s.swapTask.CleanupReturnAddress),
+	)
+	sig, err := s.TransferBalance(ctx, fromToken, fromAddress, amount, dest)
+	s.recordTransfer(0, TransferStatus{
+		Mint:        fromToken,
+		Destination: dest.String(),
+		Amount:      amount,
+		Reason:      "cleanup",
+	}, sig, err)
+	return err
+}
+
+// closeEmptyAccounts closes the WSOL account of the wallet, which unwraps
+// its balance, and the token accounts of the task left empty. Accounts used
+// by other running tasks of the wallet are kept.
+func (s *TokenSwapper) closeEmptyAccounts(ctx context.Context) error {
+	err := s.UpdateBalances(ctx)
+	if err != nil {
+		return err
+	}
+
+	accounts := []solana.PublicKey{}
+	for mint, account := range s.tokenAccounts {
+		if mint == config.NativeSOL || mint == config.WrappedSOL || s.balance(account) > 0 {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	wsol, _, err := GetTokenAccountsFromMints(ctx, *s.clientRPC, s.account.PublicKey(), solana.MustPublicKeyFromBase58(config.WrappedSOL))
+	if err != nil {
+		return err
+	}
+	if account, ok := wsol[config.WrappedSOL]; ok {
+		accounts = append(accounts, account)
+	}
+
+	instrs := []solana.Instruction{}
+	for _, account := range accounts {
+		if s.accountInUse(account) {
+			s.logger.Info("token account used by another task, not closed", zap.String("account", account.String()))
+			continue
+		}
+		inst, err := token.NewCloseAccountInstruction(
+			account,
+			s.account.PublicKey(),
+			s.account.PublicKey(),
+			[]solana.PublicKey{},
+		).ValidateAndBuild()
+		if err != nil {
+			return err
+		}
+		instrs = append(instrs, inst)
+	}
+	if len(instrs) == 0 {
+		return nil
+	}
+	sig, err := ExecuteInstructionsAndWaitConfirm(ctx, s.clientRPC, s.RPCWs, []solana.PrivateKey{s.account}, instrs...)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("token accounts closed", zap.Int("accounts", len(instrs)), zap.String("txID", sig.String()))
+	return nil
+}
+
+// accountInUse tells if another task of the wallet, not ended, swaps with
+// the token account.
+func (s *TokenSwapper) accountInUse(account solana.PublicKey) bool {
+	for _, other := range s.swappers {
+		if other == s || other.State().Ended() {
+			continue
+		}
+		for _, a := range other.tokenAccounts {
+			if a.Equals(account) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidExitTrigger = errors.New("trailingStop and takeProfit need the sell side and an exitAmount")
+	ErrExitCompleted      = errors.New("exit amount sold")
+)
+
+// ExitState is saved in the store so the high-water mark and the trigger
+// survive restarts.
+type ExitState struct {
+	HighPrice     float64
+	Triggered     bool
+	TriggerReason string `json:",omitempty"`
+	TriggerPrice  float64
+	Sold          uint64
+}
+
+func exitKey(taskID string) string {
+	return fmt.Sprintf("exit_%s", taskID)
+}
+
+// hasExitTrigger tells if the sell task waits for a trailing stop or take
+// profit before selling.
+func (t *SwapTaskConfig) hasExitTrigger() bool {
+	return t.TrailingStopPercent > 0 || t.TakeProfitPrice > 0
+}
+
+func (s *TokenSwapper) GetExitState() (*ExitState, error) {
+	var state ExitState
+	_, err := s.store.Get(exitKey(s.swapTask.ID), &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// CheckExitTrigger updates the high-water mark with the pool price and fires
+// the trigger when the price drops TrailingStopPercent below it, or reaches
+// TakeProfitPrice. Once triggered it stays so until ExitAmount is sold.
+func (s *TokenSwapper) CheckExitTrigger(ctx context.Context) (*ExitState, error) {
+	state, err := s.GetExitState()
+	if err != nil {
+		return nil, err
+	}
+	if state.Triggered {
+		return state, nil
+	}
+
+	price, err := s.GetPoolPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if price > state.HighPrice {
+		state.HighPrice = price
+	}
+
+	stopPrice := state.HighPrice * (1 - s.swapTask.TrailingStopPercent/100)
+	if s.swapTask.TrailingStopPercent > 0 && price <= stopPrice {
+		state.Triggered = true
+		state.TriggerReason = "trailing stop"
+	}
+	if s.swapTask.TakeProfitPrice > 0 && price >= s.swapTask.TakeProfitPrice {
+		state.Triggered = true
+		state.TriggerReason = "take profit"
+	}
+
+	if state.Triggered {
+		state.TriggerPrice = price
+		s.logger.Info(state.TriggerReason+" triggered, starting to sell",
+			zap.Float64("price", price),
+			zap.Float64("highPrice", state.HighPrice),
+			zap.Float64("exitAmount", s.swapTask.ExitAmount),
+		)
+	} else {
+		s.logger.Info("waiting for exit trigger",
+			zap.Float64("price", price),
+			zap.Float64("highPrice", state.HighPrice),
+			zap.Float64("trailingStopPrice", stopPrice),
+			zap.Float64("takeProfitPrice", s.swapTask.TakeProfitPrice),
+		)
+	}
+
+	err = s.store.Set(exitKey(s.swapTask.ID), state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// RecordExitSold adds a successful sell to the exit state.
+func (s *TokenSwapper) RecordExitSold(state *ExitState, amount uint64) error {
+	state.Sold += amount
+	return s.store.Set(exitKey(s.swapTask.ID), state)
+}
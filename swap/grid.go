@@ -0,0 +1,162 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidGrid = errors.New("grid needs gridLower > 0, gridUpper > gridLower and at least 2 gridLevels")
+)
+
+// GridState is saved in the store so the grid survives restarts. Filled[i]
+// is set when we bought at level i and are waiting to sell at level i+1.
+type GridState struct {
+	LastPrice float64
+	Filled    []bool
+}
+
+func gridKey(taskID string) string {
+	return fmt.Sprintf("grid_%s", taskID)
+}
+
+// GridLevel returns the price of level i.
+func (t *SwapTaskConfig) GridLevel(i int) float64 {
+	step := (t.GridUpper - t.GridLower) / float64(t.GridLevels-1)
+	return t.GridLower + step*float64(i)
+}
+
+// Grid buys Amount of quote token for every level the pool price crossed
+// going down, and sells the same value for every level crossed going up
+// above a filled level.
+func (s *TokenSwapper) Grid(ctx context.Context) error {
+	var state GridState
+	_, err := s.store.Get(gridKey(s.swapTask.ID), &state)
+	if err != nil {
+		return err
+	}
+
+	price, err := s.GetPoolPrice(ctx)
+	if err != nil {
+		s.logger.Warn("fail to get pool price", zap.Error(err))
+		return err
+	}
+
+	if len(state.Filled) != s.swapTask.GridLevels-1 || state.LastPrice == 0 {
+		s.logger.Info("starting grid",
+			zap.Float64("price", price),
+			zap.Float64("gridLower", s.swapTask.GridLower),
+			zap.Float64("gridUpper", s.swapTask.GridUpper),
+			zap.Int("gridLevels", s.swapTask.GridLevels),
+		)
+		state = GridState{
+			LastPrice: price,
+			Filled:    make([]bool, s.swapTask.GridLevels-1),
+		}
+		return s.store.Set(gridKey(s.swapTask.ID), state)
+	}
+
+	side := SwapSide_Buy
+	levels := []int{}
+	for i := range state.Filled {
+		level := s.swapTask.GridLevel(i)
+		// Going down through a level we buy at it
+		if !state.Filled[i] && price <= level && level < state.LastPrice {
+			levels = append(levels, i)
+		}
+	}
+	if len(levels) == 0 {
+		side = SwapSide_Sell
+		for i := range state.Filled {
+			level := s.swapTask.GridLevel(i + 1)
+			// Going up through the next level we sell what was bought
+			if state.Filled[i] && price >= level && level > state.LastPrice {
+				levels = append(levels, i)
+			}
+		}
+	}
+
+	if len(levels) == 0 {
+		state.LastPrice = price
+		return s.store.Set(gridKey(s.swapTask.ID), state)
+	}
+
+	fromToken, _ := s.sideTokens(side)
+	fromTokenInfo := s.tokens[fromToken]
+	fromBalance := s.balance(s.tokenAccounts[fromToken])
+	value := s.swapTask.Amount * float64(len(levels))
+	amount := fromTokenInfo.FromFloat(value)
+	if side == SwapSide_Sell {
+		amount = fromTokenInfo.FromFloat(value / price)
+	}
+	// The budgets only fill whole levels, the grid sells what each one bought
+	if s.hasBudget(side) {
+		levelAmount := amount / uint64(len(levels))
+		if levelAmount == 0 {
+			levelAmount = 1
+		}
+		capped, err := s.capToBudget(fromTokenInfo, amount, levelAmount)
+		if err != nil {
+			return err
+		}
+		if capped < amount {
+			levels = levels[:capped/levelAmount]
+			amount = levelAmount * uint64(len(levels))
+		}
+	}
+	s.logger.Info("grid levels crossed",
+		zap.Float64("price", price),
+		zap.Float64("lastPrice", state.LastPrice),
+		zap.String("side", string(side)),
+		zap.Ints("levels", levels),
+		zap.Uint64("amount", amount),
+	)
+
+	if fromToken == config.NativeSOL {
+		reserve, err := s.FeeReserve(ctx)
+		if err != nil {
+			return err
+		}
+		if amount+reserve > fromBalance {
+			s.notifyReserveReached("SOL balance too low to fill the grid and keep the fee reserve",
+				zap.Uint64("swapAmount", amount),
+				zap.Uint64("reserve", reserve),
+				zap.Uint64("currentBalance", fromBalance),
+			)
+			return ErrFeeReserveReached
+		}
+		s.progress.ReserveReachedNotified = false
+	}
+
+	if amount > fromBalance {
+		s.logger.Warn("not enough balance to swap "+fromTokenInfo.Symbol+" for grid",
+			zap.Uint64("swapAmount", amount),
+			zap.Uint64("currentBalance", fromBalance),
+		)
+		return ErrFromBalanceNotEnough
+	}
+
+	status := SwapStatus{
+		Pair:  s.swapTask.Pair,
+		Side:  side,
+		Price: price,
+	}
+	err = s.ExecuteSwap(ctx, amount, &status)
+	if stopsSwaps(err) {
+		return err
+	}
+	if err != nil {
+		// The levels are still crossed on the next interval if the price stays
+		return nil
+	}
+
+	for _, i := range levels {
+		state.Filled[i] = side == SwapSide_Buy
+	}
+	state.LastPrice = price
+	return s.store.Set(gridKey(s.swapTask.ID), state)
+}
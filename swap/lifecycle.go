@@ -0,0 +1,149 @@
+package swap
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type TaskState string
+
+const (
+	TaskState_Running   TaskState = "running"
+	TaskState_Paused    TaskState = "paused"
+	TaskState_Completed TaskState = "completed"
+	TaskState_Stopped   TaskState = "stopped"
+	TaskState_Failed    TaskState = "failed"
+)
+
+// Process exit codes for the final task state
+const (
+	ExitCode_Completed = 0
+	ExitCode_Failed    = 1
+	ExitCode_Stopped   = 3
+)
+
+// Ended tells if the task will not run anymore.
+func (t TaskState) Ended() bool {
+	return t == TaskState_Completed || t == TaskState_Stopped || t == TaskState_Failed
+}
+
+// ExitCode returns the process exit code for an ended task.
+func (t TaskState) ExitCode() int {
+	switch t {
+	case TaskState_Completed:
+		return ExitCode_Completed
+	case TaskState_Stopped:
+		return ExitCode_Stopped
+	}
+	return ExitCode_Failed
+}
+
+// taskStateFor returns the task state after a run of Start. Other errors
+// are tried again at the next interval.
+func taskStateFor(err error) TaskState {
+	switch {
+	case err == nil:
+		return TaskState_Running
+	case errors.Is(err, ErrStopAmountReached),
+		errors.Is(err, ErrExitCompleted),
+		errors.Is(err, ErrTotalBudgetReached):
+		return TaskState_Completed
+	case IsFatal(err):
+		return TaskState_Failed
+	case errors.Is(err, ErrDailyBudgetReached),
+		errors.Is(err, ErrVolatilityTooHigh),
+		errors.Is(err, ErrFeeReserveReached),
+		errors.Is(err, ErrFromBalanceNotEnough),
+		errors.Is(err, ErrInsufficientFunds),
+		errors.Is(err, ErrCircuitOpen):
+		return TaskState_Paused
+	}
+	return TaskState_Running
+}
+
+// Run executes one interval of the task and updates its state. This is the
+// job given to the scheduler, it does nothing once the task has ended.
+func (s *TokenSwapper) Run() {
+	if s.State().Ended() {
+		return
+	}
+	now := time.Now()
+	// Slices of a task never overlap, the next run sees this tick as missed
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		s.recordTick(TickDecision{Timestamp: now.Unix(), Policy: s.swapTask.MissedTickPolicy, Overlap: true})
+		return
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	missed := s.missedTicks(now)
+	runs, size := s.tickSlices(missed)
+	s.recordTick(TickDecision{
+		Timestamp: now.Unix(),
+		Policy:    s.swapTask.MissedTickPolicy,
+		Missed:    missed,
+		Slices:    runs * size,
+	})
+	s.progress.LastRun = now.Unix()
+	s.sliceSize = size
+	for i := 0; i < runs; i++ {
+		err := s.Start()
+		s.setState(taskStateFor(err), err)
+		if err != nil || s.State() != TaskState_Running {
+			break
+		}
+	}
+	s.sliceSize = 1
+	if s.State() == TaskState_Completed && !s.progress.CleanedUp {
+		s.Cleanup()
+		s.progress.CleanedUp = true
+	}
+	s.saveProgress()
+}
+
+// Wait returns once the current run of the task, if any, is over.
+func (s *TokenSwapper) Wait() {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+}
+
+// Stop ends the task, the current run if any is not interrupted.
+func (s *TokenSwapper) Stop() {
+	s.setState(TaskState_Stopped, nil)
+}
+
+func (s *TokenSwapper) State() TaskState {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// Done is closed when the task has ended.
+func (s *TokenSwapper) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *TokenSwapper) setState(state TaskState, err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.state == state || s.state.Ended() {
+		return
+	}
+	s.logger.Info("task state changed",
+		zap.String("from", string(s.state)),
+		zap.String("to", string(state)),
+		zap.NamedError("reason", err),
+	)
+	s.state = state
+	if state.Ended() {
+		if state != TaskState_Stopped {
+			s.notify("task "+string(state), zap.NamedError("reason", err))
+		}
+		close(s.done)
+	}
+}
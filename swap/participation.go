@@ -0,0 +1,66 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/gopartyparrot/goparrot-twap/config"
+)
+
+var (
+	ErrInvalidPovPercent = errors.New("participation percent must be between 0 and 100")
+	ErrPoolMintMismatch  = errors.New("token is not part of the pool")
+)
+
+// participation keeps the pool cumulative swap volume seen at the previous
+// slice and the amount we swapped since.
+type participation struct {
+	lastVolume *big.Int
+	ownAmount  uint64
+}
+
+// ParticipationAmount sizes the slice so that our swaps are PovPercent of the
+// pool from token volume since the previous slice. The result is kept between
+// PovMinAmount and Amount. The first call only records the volume reference
+// and returns 0.
+func (s *TokenSwapper) ParticipationAmount(ctx context.Context, fromTokenInfo config.TokenInfo) (uint64, error) {
+	amm, err := s.raydiumSwap.GetAmmInfo(ctx, &s.swapTask.pool.RaydiumPoolConfig)
+	if err != nil {
+		return 0, err
+	}
+	volume, ok := amm.SwapVolume(s.swapTask.fromToken)
+	if !ok {
+		return 0, ErrPoolMintMismatch
+	}
+
+	prev := s.participation.lastVolume
+	own := new(big.Int).SetUint64(s.participation.ownAmount)
+	s.participation.lastVolume = volume
+	s.participation.ownAmount = 0
+	if prev == nil {
+		return 0, nil
+	}
+
+	// Only count what others traded, our own swaps are in the counters too
+	traded := new(big.Int).Sub(volume, prev)
+	traded.Sub(traded, own)
+	if traded.Sign() < 0 {
+		traded.SetInt64(0)
+	}
+	tradedFloat, _ := new(big.Float).SetInt(traded).Float64()
+
+	// Our share p of the total volume: amount / (amount + traded) = p
+	p := s.swapTask.PovPercent / 100
+	amount := uint64(tradedFloat * p / (1 - p))
+
+	minAmount := fromTokenInfo.FromFloat(s.swapTask.PovMinAmount)
+	maxAmount := fromTokenInfo.FromFloat(s.swapTask.Amount)
+	if amount < minAmount {
+		amount = minAmount
+	}
+	if amount > maxAmount {
+		amount = maxAmount
+	}
+	return amount, nil
+}
@@ -0,0 +1,105 @@
+package swap
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gopartyparrot/goparrot-twap/price"
+)
+
+var (
+	ErrUnknownPriceSource = errors.New("unknown price source, can be pool or coingecko")
+	ErrNoCoinGeckoID      = errors.New("no CoinGeckoID configured for the token, use the pool price source")
+	ErrInvalidPrice       = errors.New("price must be greater than zero")
+	ErrEmptyPool          = errors.New("pool reserves are empty")
+)
+
+type PriceSource string
+
+const (
+	PriceSource_CoinGecko PriceSource = "coingecko"
+	PriceSource_Pool      PriceSource = "pool"
+)
+
+// GetCurrentPrice returns the price of the pair token from the task price
+// source. Pool prices are in quote token units, CoinGecko prices are in USD.
+func (s *TokenSwapper) GetCurrentPrice(ctx context.Context) (float64, error) {
+	switch s.swapTask.PriceSource {
+	case PriceSource_Pool:
+		return s.GetPoolPrice(ctx)
+	case PriceSource_CoinGecko, "":
+		return s.GetCoinGeckoPrice(ctx)
+	}
+	return 0, ErrUnknownPriceSource
+}
+
+func (s *TokenSwapper) GetCoinGeckoPrice(ctx context.Context) (float64, error) {
+	return coinGeckoPrice(s.swapTask.pool.CoinGeckoID, "usd")
+}
+
+func coinGeckoPrice(id string, currency string) (float64, error) {
+	client := price.NewClient(nil)
+	res, err := client.SimplePrice([]string{id}, []string{currency})
+	if err != nil {
+		return 0, err
+	}
+	price := *res
+	return float64(price[id][currency]), nil
+}
+
+// NotionalRate returns the price of one from token in the notional currency.
+// With the pool price source the notional currency is the quote token of the
+// pair.
+func (s *TokenSwapper) NotionalRate(ctx context.Context) (float64, error) {
+	var rate float64
+	var err error
+	switch s.swapTask.PriceSource {
+	case PriceSource_Pool:
+		rate = 1
+		if s.swapTask.fromToken != s.swapTask.pool.FromToken {
+			rate, err = s.GetPoolPrice(ctx)
+		}
+	case PriceSource_CoinGecko, "":
+		fromTokenInfo := s.tokens[s.swapTask.fromToken]
+		rate, err = coinGeckoPrice(fromTokenInfo.CoinGeckoID, s.swapTask.NotionalCurrency)
+	default:
+		err = ErrUnknownPriceSource
+	}
+	if err != nil {
+		return 0, err
+	}
+	if rate <= 0 {
+		return 0, ErrInvalidPrice
+	}
+	return rate, nil
+}
+
+// GetPoolPrice returns the spot price from the pool reserves, that is how many
+// quote tokens (pool FromToken) for one base token (pool ToToken), e.g. SOL
+// per PRT for the PRT:SOL pair.
+func (s *TokenSwapper) GetPoolPrice(ctx context.Context) (float64, error) {
+	reserves, err := s.raydiumSwap.GetPoolReserves(ctx, &s.swapTask.pool.RaydiumPoolConfig)
+	if err != nil {
+		return 0, err
+	}
+	return s.poolPrice(reserves)
+}
+
+func (s *TokenSwapper) poolPrice(reserves *PoolReserves) (float64, error) {
+	quoteToken := s.swapTask.pool.FromToken
+	baseToken := s.swapTask.pool.ToToken
+	quoteReserve, ok := reserves.Reserve(quoteToken)
+	if !ok {
+		return 0, ErrPoolMintMismatch
+	}
+	baseReserve, ok := reserves.Reserve(baseToken)
+	if !ok {
+		return 0, ErrPoolMintMismatch
+	}
+	if baseReserve == 0 {
+		return 0, ErrEmptyPool
+	}
+	quoteInfo := s.tokens[quoteToken]
+	baseInfo := s.tokens[baseToken]
+	return quoteInfo.ToFloat(quoteReserve) / baseInfo.ToFloat(baseReserve), nil
+}
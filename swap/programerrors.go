@@ -0,0 +1,326 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+	"github.com/gopartyparrot/goparrot-twap/config"
+)
+
+// ProgramError is a custom error code returned by an on-chain program.
+type ProgramError struct {
+	Program string
+	Code    uint32
+	Name    string
+	Message string
+	// retryable or fatal error the code is classified as, if any
+	class error
+}
+
+func (e *ProgramError) Error() string {
+	return fmt.Sprintf("%s: %s (%s, %d)", e.Program, e.Message, e.Name, e.Code)
+}
+
+func newProgramError(program string, code uint32, name string, message string, class error) *ProgramError {
+	return &ProgramError{Program: program, Code: code, Name: name, Message: message, class: class}
+}
+
+const (
+	raydiumProgramName = "raydium amm"
+	tokenProgramName   = "spl token"
+)
+
+// Raydium AMM v4 errors
+var (
+	ErrRaydiumAlreadyInUse              = newProgramError(raydiumProgramName, 0, "AlreadyInUse", "account already in use", nil)
+	ErrRaydiumInvalidProgramAddress     = newProgramError(raydiumProgramName, 1, "InvalidProgramAddress", "invalid program address generated from nonce and key", ErrWrongPool)
+	ErrRaydiumExpectedMint              = newProgramError(raydiumProgramName, 2, "ExpectedMint", "input account must be a mint", nil)
+	ErrRaydiumExpectedAccount           = newProgramError(raydiumProgramName, 3, "ExpectedAccount", "input account must be a token account", nil)
+	ErrRaydiumInvalidCoinVault          = newProgramError(raydiumProgramName, 4, "InvalidCoinVault", "pool coin vault does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidPCVault            = newProgramError(raydiumProgramName, 5, "InvalidPCVault", "pool pc vault does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidTokenLP            = newProgramError(raydiumProgramName, 6, "InvalidTokenLP", "invalid lp token account", ErrWrongPool)
+	ErrRaydiumInvalidDestTokenCoin      = newProgramError(raydiumProgramName, 7, "InvalidDestTokenCoin", "invalid destination coin token account", nil)
+	ErrRaydiumInvalidDestTokenPC        = newProgramError(raydiumProgramName, 8, "InvalidDestTokenPC", "invalid destination pc token account", nil)
+	ErrRaydiumInvalidPoolMint           = newProgramError(raydiumProgramName, 9, "InvalidPoolMint", "pool lp mint does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidOpenOrders         = newProgramError(raydiumProgramName, 10, "InvalidOpenOrders", "open orders do not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidSerumMarket        = newProgramError(raydiumProgramName, 11, "InvalidSerumMarket", "serum market does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidSerumProgram       = newProgramError(raydiumProgramName, 12, "InvalidSerumProgram", "serum program does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidTargetOrders       = newProgramError(raydiumProgramName, 13, "InvalidTargetOrders", "target orders do not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidWithdrawQueue      = newProgramError(raydiumProgramName, 14, "InvalidWithdrawQueue", "withdraw queue does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidTempLp             = newProgramError(raydiumProgramName, 15, "InvalidTempLp", "temp lp account does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidCoinMint           = newProgramError(raydiumProgramName, 16, "InvalidCoinMint", "coin mint does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidPCMint             = newProgramError(raydiumProgramName, 17, "InvalidPCMint", "pc mint does not match the amm", ErrWrongPool)
+	ErrRaydiumInvalidOwner              = newProgramError(raydiumProgramName, 18, "InvalidOwner", "token account owner is not the amm authority", ErrWrongPool)
+	ErrRaydiumInvalidSupply             = newProgramError(raydiumProgramName, 19, "InvalidSupply", "lp mint supply is not zero", nil)
+	ErrRaydiumInvalidDelegate           = newProgramError(raydiumProgramName, 20, "InvalidDelegate", "token account has a delegate", nil)
+	ErrRaydiumInvalidSignAccount        = newProgramError(raydiumProgramName, 21, "InvalidSignAccount", "user account did not sign", nil)
+	ErrRaydiumInvalidStatus             = newProgramError(raydiumProgramName, 22, "InvalidStatus", "amm status does not allow swaps", nil)
+	ErrRaydiumInvalidInstruction        = newProgramError(raydiumProgramName, 23, "InvalidInstruction", "invalid instruction", nil)
+	ErrRaydiumWrongAccountsNumber       = newProgramError(raydiumProgramName, 24, "WrongAccountsNumber", "wrong number of accounts", nil)
+	ErrRaydiumWithdrawTransferBusy      = newProgramError(raydiumProgramName, 25, "WithdrawTransferBusy", "withdraw transfer is busy", nil)
+	ErrRaydiumWithdrawQueueFull         = newProgramError(raydiumProgramName, 26, "WithdrawQueueFull", "withdraw queue is full", nil)
+	ErrRaydiumWithdrawQueueEmpty        = newProgramError(raydiumProgramName, 27, "WithdrawQueueEmpty", "withdraw queue is empty", nil)
+	ErrRaydiumInvalidParamsSet          = newProgramError(raydiumProgramName, 28, "InvalidParamsSet", "invalid amm parameters", nil)
+	ErrRaydiumInvalidInput              = newProgramError(raydiumProgramName, 29, "InvalidInput", "invalid input", nil)
+	ErrRaydiumExceededSlippage          = newProgramError(raydiumProgramName, 30, "ExceededSlippage", "output is below the minimum amount out", ErrSlippageExceeded)
+	ErrRaydiumCalculationExRateFailure  = newProgramError(raydiumProgramName, 31, "CalculationExRateFailure", "exchange rate calculation failed", nil)
+	ErrRaydiumCheckedSubOverflow        = newProgramError(raydiumProgramName, 32, "CheckedSubOverflow", "subtraction overflow", nil)
+	ErrRaydiumCheckedAddOverflow        = newProgramError(raydiumProgramName, 33, "CheckedAddOverflow", "addition overflow", nil)
+	ErrRaydiumCheckedMulOverflow        = newProgramError(raydiumProgramName, 34, "CheckedMulOverflow", "multiplication overflow", nil)
+	ErrRaydiumCheckedDivOverflow        = newProgramError(raydiumProgramName, 35, "CheckedDivOverflow", "division overflow", nil)
+	ErrRaydiumCheckedEmptyFunds         = newProgramError(raydiumProgramName, 36, "CheckedEmptyFunds", "pool funds are empty", nil)
+	ErrRaydiumCalcPnlError              = newProgramError(raydiumProgramName, 37, "CalcPnlError", "pnl calculation failed", nil)
+	ErrRaydiumInvalidSplTokenProgram    = newProgramError(raydiumProgramName, 38, "InvalidSplTokenProgram", "invalid spl token program", nil)
+	ErrRaydiumTakePnlError              = newProgramError(raydiumProgramName, 39, "TakePnlError", "take pnl failed", nil)
+	ErrRaydiumInsufficientFunds         = newProgramError(raydiumProgramName, 40, "InsufficientFunds", "insufficient funds", nil)
+	ErrRaydiumConversionFailure         = newProgramError(raydiumProgramName, 41, "ConversionFailure", "number conversion failed", nil)
+	ErrRaydiumInvalidUserToken          = newProgramError(raydiumProgramName, 42, "InvalidUserToken", "user token account does not match the pool mints", ErrWrongPool)
+	ErrRaydiumInvalidSrmMint            = newProgramError(raydiumProgramName, 43, "InvalidSrmMint", "invalid srm mint", nil)
+	ErrRaydiumInvalidSrmToken           = newProgramError(raydiumProgramName, 44, "InvalidSrmToken", "invalid srm token account", nil)
+	ErrRaydiumTooManyOpenOrders         = newProgramError(raydiumProgramName, 45, "TooManyOpenOrders", "too many open orders", nil)
+	ErrRaydiumOrderAtSlotIsPlaced       = newProgramError(raydiumProgramName, 46, "OrderAtSlotIsPlaced", "an order is already placed at this slot", nil)
+	ErrRaydiumInvalidSysProgramAddress  = newProgramError(raydiumProgramName, 47, "InvalidSysProgramAddress", "invalid system program address", nil)
+	ErrRaydiumInvalidFee                = newProgramError(raydiumProgramName, 48, "InvalidFee", "invalid fee", nil)
+	ErrRaydiumRepeatCreateAmm           = newProgramError(raydiumProgramName, 49, "RepeatCreateAmm", "amm already exists", nil)
+	ErrRaydiumNotAllowZeroLP            = newProgramError(raydiumProgramName, 50, "NotAllowZeroLP", "zero lp is not allowed", nil)
+	ErrRaydiumInvalidCloseAuthority     = newProgramError(raydiumProgramName, 51, "InvalidCloseAuthority", "invalid close authority", nil)
+	ErrRaydiumInvalidFreezeAuthority    = newProgramError(raydiumProgramName, 52, "InvalidFreezeAuthority", "invalid freeze authority", nil)
+	ErrRaydiumInvalidReferPCMint        = newProgramError(raydiumProgramName, 53, "InvalidReferPCMint", "invalid referrer pc mint", nil)
+	ErrRaydiumInvalidConfigAccount      = newProgramError(raydiumProgramName, 54, "InvalidConfigAccount", "invalid config account", nil)
+	ErrRaydiumRepeatCreateConfigAccount = newProgramError(raydiumProgramName, 55, "RepeatCreateConfigAccount", "config account already exists", nil)
+	ErrRaydiumUnknownAmmError           = newProgramError(raydiumProgramName, 56, "UnknownAmmError", "unknown amm error", nil)
+)
+
+// SPL token program errors
+var (
+	ErrTokenNotRentExempt                  = newProgramError(tokenProgramName, 0, "NotRentExempt", "lamport balance below rent-exempt threshold", nil)
+	ErrTokenInsufficientFunds              = newProgramError(tokenProgramName, 1, "InsufficientFunds", "insufficient funds", nil)
+	ErrTokenInvalidMint                    = newProgramError(tokenProgramName, 2, "InvalidMint", "invalid mint", nil)
+	ErrTokenMintMismatch                   = newProgramError(tokenProgramName, 3, "MintMismatch", "account not associated with this mint", ErrWrongPool)
+	ErrTokenOwnerMismatch                  = newProgramError(tokenProgramName, 4, "OwnerMismatch", "owner does not match", nil)
+	ErrTokenFixedSupply                    = newProgramError(tokenProgramName, 5, "FixedSupply", "fixed supply", nil)
+	ErrTokenAlreadyInUse                   = newProgramError(tokenProgramName, 6, "AlreadyInUse", "account already in use", nil)
+	ErrTokenInvalidNumberOfProvidedSigners = newProgramError(tokenProgramName, 7, "InvalidNumberOfProvidedSigners", "invalid number of provided signers", nil)
+	ErrTokenInvalidNumberOfRequiredSigners = newProgramError(tokenProgramName, 8, "InvalidNumberOfRequiredSigners", "invalid number of required signers", nil)
+	ErrTokenUninitializedState             = newProgramError(tokenProgramName, 9, "UninitializedState", "token account is not initialized", ErrAccountNotFound)
+	ErrTokenNativeNotSupported             = newProgramError(tokenProgramName, 10, "NativeNotSupported", "instruction does not support native tokens", nil)
+	ErrTokenNonNativeHasBalance            = newProgramError(tokenProgramName, 11, "NonNativeHasBalance", "non-native account can only be closed if its balance is zero", nil)
+	ErrTokenInvalidInstruction             = newProgramError(tokenProgramName, 12, "InvalidInstruction", "invalid instruction", nil)
+	ErrTokenInvalidState                   = newProgramError(tokenProgramName, 13, "InvalidState", "state is invalid for requested operation", nil)
+	ErrTokenOverflow                       = newProgramError(tokenProgramName, 14, "Overflow", "operation overflowed", nil)
+	ErrTokenAuthorityTypeNotSupported      = newProgramError(tokenProgramName, 15, "AuthorityTypeNotSupported", "account does not support specified authority type", nil)
+	ErrTokenMintCannotFreeze               = newProgramError(tokenProgramName, 16, "MintCannotFreeze", "this token mint cannot freeze accounts", nil)
+	ErrTokenAccountFrozen                  = newProgramError(tokenProgramName, 17, "AccountFrozen", "account is frozen", nil)
+	ErrTokenMintDecimalsMismatch           = newProgramError(tokenProgramName, 18, "MintDecimalsMismatch", "the provided decimals value different from the mint decimals", nil)
+	ErrTokenNonNativeNotSupported          = newProgramError(tokenProgramName, 19, "NonNativeNotSupported", "instruction does not support non-native tokens", nil)
+)
+
+// programErrors maps program ids to their errors by code
+var programErrors = map[string]map[uint32]*ProgramError{
+	config.RaydiumLiquidityPoolProgramIDV4: programErrorCodes(
+		ErrRaydiumAlreadyInUse, ErrRaydiumInvalidProgramAddress, ErrRaydiumExpectedMint, ErrRaydiumExpectedAccount,
+		ErrRaydiumInvalidCoinVault, ErrRaydiumInvalidPCVault, ErrRaydiumInvalidTokenLP, ErrRaydiumInvalidDestTokenCoin,
+		ErrRaydiumInvalidDestTokenPC, ErrRaydiumInvalidPoolMint, ErrRaydiumInvalidOpenOrders, ErrRaydiumInvalidSerumMarket,
+		ErrRaydiumInvalidSerumProgram, ErrRaydiumInvalidTargetOrders, ErrRaydiumInvalidWithdrawQueue, ErrRaydiumInvalidTempLp,
+		ErrRaydiumInvalidCoinMint, ErrRaydiumInvalidPCMint, ErrRaydiumInvalidOwner, ErrRaydiumInvalidSupply,
+		ErrRaydiumInvalidDelegate, ErrRaydiumInvalidSignAccount, ErrRaydiumInvalidStatus, ErrRaydiumInvalidInstruction,
+		ErrRaydiumWrongAccountsNumber, ErrRaydiumWithdrawTransferBusy, ErrRaydiumWithdrawQueueFull, ErrRaydiumWithdrawQueueEmpty,
+		ErrRaydiumInvalidParamsSet, ErrRaydiumInvalidInput, ErrRaydiumExceededSlippage, ErrRaydiumCalculationExRateFailure,
+		ErrRaydiumCheckedSubOverflow, ErrRaydiumCheckedAddOverflow, ErrRaydiumCheckedMulOverflow, ErrRaydiumCheckedDivOverflow,
+		ErrRaydiumCheckedEmptyFunds, ErrRaydiumCalcPnlError, ErrRaydiumInvalidSplTokenProgram, ErrRaydiumTakePnlError,
+		ErrRaydiumInsufficientFunds, ErrRaydiumConversionFailure, ErrRaydiumInvalidUserToken, ErrRaydiumInvalidSrmMint,
+		ErrRaydiumInvalidSrmToken, ErrRaydiumTooManyOpenOrders, ErrRaydiumOrderAtSlotIsPlaced, ErrRaydiumInvalidSysProgramAddress,
+		ErrRaydiumInvalidFee, ErrRaydiumRepeatCreateAmm, ErrRaydiumNotAllowZeroLP, ErrRaydiumInvalidCloseAuthority,
+		ErrRaydiumInvalidFreezeAuthority, ErrRaydiumInvalidReferPCMint, ErrRaydiumInvalidConfigAccount,
+		ErrRaydiumRepeatCreateConfigAccount, ErrRaydiumUnknownAmmError,
+	),
+	solana.TokenProgramID.String(): programErrorCodes(
+		ErrTokenNotRentExempt, ErrTokenInsufficientFunds, ErrTokenInvalidMint, ErrTokenMintMismatch,
+		ErrTokenOwnerMismatch, ErrTokenFixedSupply, ErrTokenAlreadyInUse, ErrTokenInvalidNumberOfProvidedSigners,
+		ErrTokenInvalidNumberOfRequiredSigners, ErrTokenUninitializedState, ErrTokenNativeNotSupported,
+		ErrTokenNonNativeHasBalance, ErrTokenInvalidInstruction, ErrTokenInvalidState, ErrTokenOverflow,
+		ErrTokenAuthorityTypeNotSupported, ErrTokenMintCannotFreeze, ErrTokenAccountFrozen,
+		ErrTokenMintDecimalsMismatch, ErrTokenNonNativeNotSupported,
+	),
+}
+
+func programErrorCodes(errs ...*ProgramError) map[uint32]*ProgramError {
+	codes := map[uint32]*ProgramError{}
+	for _, err := range errs {
+		codes[err.Code] = err
+	}
+	return codes
+}
+
+// LookupProgramError returns the known error of a program custom code.
+func LookupProgramError(programID string, code uint32) (*ProgramError, bool) {
+	err, ok := programErrors[programID][code]
+	return err, ok
+}
+
+// InstructionError is the error of one instruction of a transaction.
+type InstructionError struct {
+	Index   int
+	Program string
+	Err     error
+}
+
+func (e *InstructionError) Error() string {
+	if e.Program == "" {
+		return fmt.Sprintf("instruction %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("instruction %d (%s): %v", e.Index, e.Program, e.Err)
+}
+
+func (e *InstructionError) Unwrap() error {
+	return e.Err
+}
+
+// TransactionError is a failed transaction, from the preflight simulation or
+// from its confirmation. Logs are only there for the simulation.
+type TransactionError struct {
+	Err  error
+	Logs []string
+}
+
+func (e *TransactionError) Error() string {
+	return "transaction failed: " + e.Err.Error()
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// failed program log: "Program <id> failed: custom program error: 0x1e"
+var programFailedLog = regexp.MustCompile(`^Program (\w+) failed: custom program error: 0x([0-9a-fA-F]+)`)
+
+// DecodeProgramLogs returns the program error of the failed program in the
+// simulation logs, nil when there is none.
+func DecodeProgramLogs(logs []string) error {
+	for _, line := range logs {
+		m := programFailedLog.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		code, err := strconv.ParseUint(m[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		return customError(m[1], uint32(code))
+	}
+	return nil
+}
+
+// DecodeTransactionError decodes the JSON err value of a transaction status.
+// The instruction programs are taken from tx when it is given.
+func DecodeTransactionError(txErr interface{}, tx *solana.Transaction) error {
+	switch v := txErr.(type) {
+	case nil:
+		return nil
+	case string:
+		switch v {
+		case "BlockhashNotFound":
+			return ErrBlockhashExpired
+		case "ProgramAccountNotFound":
+			return ErrAccountNotFound
+		// The fee payer account has no SOL
+		case "AccountNotFound", "InsufficientFundsForFee":
+			return ErrInsufficientFunds
+		}
+		return errors.New(v)
+	case map[string]interface{}:
+		detail, ok := v["InstructionError"].([]interface{})
+		if !ok || len(detail) != 2 {
+			break
+		}
+		index, ok := detail[0].(float64)
+		if !ok {
+			break
+		}
+		programID := instructionProgram(tx, int(index))
+		var err error
+		switch ie := detail[1].(type) {
+		case string:
+			err = errors.New(ie)
+		case map[string]interface{}:
+			code, ok := ie["Custom"].(float64)
+			if !ok {
+				err = fmt.Errorf("%v", ie)
+				break
+			}
+			err = customError(programID, uint32(code))
+		default:
+			err = fmt.Errorf("%v", ie)
+		}
+		return &InstructionError{Index: int(index), Program: programID, Err: err}
+	}
+	return fmt.Errorf("%v", txErr)
+}
+
+// decodeSendError replaces the error of a sent transaction, from the
+// preflight simulation or the confirmation, with a TransactionError.
+func decodeSendError(err error, tx *solana.Transaction) error {
+	var rpcErr *jsonrpc.RPCError
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+	data, ok := rpcErr.Data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("rpc error %d: %s", rpcErr.Code, rpcErr.Message)
+	}
+	var logs []string
+	if rawLogs, ok := data["logs"].([]interface{}); ok {
+		for _, l := range rawLogs {
+			if line, ok := l.(string); ok {
+				logs = append(logs, line)
+			}
+		}
+	}
+	decoded := DecodeTransactionError(data["err"], tx)
+	// The logs have the program of the failed instruction
+	if logErr := DecodeProgramLogs(logs); logErr != nil {
+		var ie *InstructionError
+		if errors.As(decoded, &ie) {
+			ie.Err = logErr
+		} else {
+			decoded = logErr
+		}
+	}
+	if decoded == nil {
+		decoded = errors.New(rpcErr.Message)
+	}
+	return &TransactionError{Err: decoded, Logs: logs}
+}
+
+func customError(programID string, code uint32) error {
+	if err, ok := LookupProgramError(programID, code); ok {
+		return err
+	}
+	return fmt.Errorf("custom program error 0x%x", code)
+}
+
+func instructionProgram(tx *solana.Transaction, index int) string {
+	if tx == nil || index < 0 || index >= len(tx.Message.Instructions) {
+		return ""
+	}
+	programIndex := int(tx.Message.Instructions[index].ProgramIDIndex)
+	if programIndex >= len(tx.Message.AccountKeys) {
+		return ""
+	}
+	return tx.Message.AccountKeys[programIndex].String()
+}
+
+// ErrorLogs returns the text saved in the swap status for err, with the
+// program logs of a failed simulation.
+func ErrorLogs(err error) string {
+	var txErr *TransactionError
+	if errors.As(err, &txErr) && len(txErr.Logs) > 0 {
+		return err.Error() + "\n" + strings.Join(txErr.Logs, "\n")
+	}
+	return err.Error()
+}
@@ -0,0 +1,137 @@
+package swap
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gopartyparrot/goparrot-twap/config"
+)
+
+// testTransaction has a first instruction of program.
+func testTransaction(program solana.PublicKey) *solana.Transaction {
+	return &solana.Transaction{
+		Message: solana.Message{
+			AccountKeys:  []solana.PublicKey{solana.NewWallet().PublicKey(), program},
+			Instructions: []solana.CompiledInstruction{{ProgramIDIndex: 1}},
+		},
+	}
+}
+
+func TestDecodeTransactionError(t *testing.T) {
+	raydiumTx := testTransaction(solana.MustPublicKeyFromBase58(config.RaydiumLiquidityPoolProgramIDV4))
+	tokenTx := testTransaction(solana.TokenProgramID)
+
+	tests := []struct {
+		name    string
+		txErr   string
+		tx      *solana.Transaction
+		wantIs  error
+		wantMsg string
+	}{
+		{name: "no error", txErr: `null`},
+		{name: "blockhash not found", txErr: `"BlockhashNotFound"`, wantIs: ErrBlockhashExpired},
+		{name: "fee payer without SOL", txErr: `"AccountNotFound"`, wantIs: ErrInsufficientFunds},
+		{name: "missing program account", txErr: `"ProgramAccountNotFound"`, wantIs: ErrAccountNotFound},
+		{name: "unknown string", txErr: `"AlreadyProcessed"`, wantMsg: "AlreadyProcessed"},
+		{
+			name:   "raydium custom error",
+			txErr:  `{"InstructionError":[0,{"Custom":30}]}`,
+			tx:     raydiumTx,
+			wantIs: ErrRaydiumExceededSlippage,
+		},
+		{
+			name:   "token custom error",
+			txErr:  `{"InstructionError":[0,{"Custom":9}]}`,
+			tx:     tokenTx,
+			wantIs: ErrTokenUninitializedState,
+		},
+		{
+			name:    "custom error without the transaction",
+			txErr:   `{"InstructionError":[0,{"Custom":30}]}`,
+			wantMsg: "instruction 0: custom program error 0x1e",
+		},
+		{
+			name:    "instruction error name",
+			txErr:   `{"InstructionError":[0,"InvalidAccountData"]}`,
+			tx:      raydiumTx,
+			wantMsg: "instruction 0 (" + config.RaydiumLiquidityPoolProgramIDV4 + "): InvalidAccountData",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var txErr interface{}
+			err := json.Unmarshal([]byte(tt.txErr), &txErr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := DecodeTransactionError(txErr, tt.tx)
+			if tt.wantIs == nil && tt.wantMsg == "" {
+				if got != nil {
+					t.Errorf("DecodeTransactionError() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("DecodeTransactionError() = nil")
+			}
+			if tt.wantIs != nil && !errors.Is(got, tt.wantIs) {
+				t.Errorf("DecodeTransactionError() = %v, want %v", got, tt.wantIs)
+			}
+			if tt.wantMsg != "" && got.Error() != tt.wantMsg {
+				t.Errorf("DecodeTransactionError() = %q, want %q", got.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestDecodeProgramLogs(t *testing.T) {
+	tests := []struct {
+		name    string
+		logs    []string
+		wantIs  error
+		wantMsg string
+	}{
+		{
+			name: "raydium slippage",
+			logs: []string{
+				"Program " + config.RaydiumLiquidityPoolProgramIDV4 + " invoke [1]",
+				"Program log: Error: exceeds desired slippage limit",
+				"Program " + config.RaydiumLiquidityPoolProgramIDV4 + " failed: custom program error: 0x1e",
+			},
+			wantIs: ErrRaydiumExceededSlippage,
+		},
+		{
+			name:   "token insufficient funds",
+			logs:   []string{"Program " + solana.TokenProgramID.String() + " failed: custom program error: 0x1"},
+			wantIs: ErrTokenInsufficientFunds,
+		},
+		{
+			name:    "unknown program",
+			logs:    []string{"Program 11111111111111111111111111111111 failed: custom program error: 0x1"},
+			wantMsg: "custom program error 0x1",
+		},
+		{
+			name: "no failed program",
+			logs: []string{"Program " + config.RaydiumLiquidityPoolProgramIDV4 + " success"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DecodeProgramLogs(tt.logs)
+			switch {
+			case tt.wantIs != nil:
+				if !errors.Is(got, tt.wantIs) {
+					t.Errorf("DecodeProgramLogs() = %v, want %v", got, tt.wantIs)
+				}
+			case tt.wantMsg != "":
+				if got == nil || got.Error() != tt.wantMsg {
+					t.Errorf("DecodeProgramLogs() = %v, want %q", got, tt.wantMsg)
+				}
+			case got != nil:
+				t.Errorf("DecodeProgramLogs() = %v, want nil", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidInterval = errors.New("task interval must be a positive duration (s, m, h)")
+)
+
+// TaskProgress is saved in the store after every run so a restarted task
+// continues where it stopped instead of starting over.
+type TaskProgress struct {
+	State    TaskState
+	Slices   int
+	Filled   map[SwapSide]uint64
+	LastRun  int64
+	LastSwap int64 `json:",omitempty"`
+
+	DepthCarry         uint64
+	PovLastVolume      string `json:",omitempty"`
+	PovOwnAmount       uint64
+	VolatilityPaused   bool
+	LowReserveNotified bool
+	// ReserveReachedNotified is set while the swaps are stopped at the fee reserve
+	ReserveReachedNotified bool
+
+	ConsecutiveFailures int
+	FailedFees          uint64
+	BreakerTripped      bool
+	BreakerTrippedAt    int64 `json:",omitempty"`
+
+	CleanedUp bool `json:",omitempty"`
+}
+
+func progressKey(taskID string) string {
+	return fmt.Sprintf("progress_%s", taskID)
+}
+
+// GetProgress returns the saved progress of the task, empty for a new task.
+func (s *TokenSwapper) GetProgress() (TaskProgress, error) {
+	var progress TaskProgress
+	_, err := s.store.Get(progressKey(s.swapTask.ID), &progress)
+	if err != nil {
+		return progress, err
+	}
+	if progress.Filled == nil {
+		progress.Filled = map[SwapSide]uint64{}
+	}
+	return progress, nil
+}
+
+// loadProgress restores the task from its saved progress. A task that has
+// completed stays completed, stopped or failed tasks run again.
+func (s *TokenSwapper) loadProgress() error {
+	progress, err := s.GetProgress()
+	if err != nil {
+		return err
+	}
+	s.progress = progress
+	if progress.PovLastVolume != "" {
+		volume, ok := new(big.Int).SetString(progress.PovLastVolume, 10)
+		if ok {
+			s.participation.lastVolume = volume
+		}
+	}
+	s.participation.ownAmount = progress.PovOwnAmount
+
+	if progress.LastRun != 0 {
+		s.logger.Info("resuming task",
+			zap.String("state", string(progress.State)),
+			zap.Int("slices", progress.Slices),
+			zap.Any("filled", progress.Filled),
+			zap.Time("lastRun", time.Unix(progress.LastRun, 0)),
+		)
+	}
+	if progress.State == TaskState_Completed {
+		s.logger.Info("task already completed")
+		s.state = TaskState_Completed
+		close(s.done)
+	}
+	// A failed task would fail again on each restart of the process
+	if progress.State == TaskState_Failed && !s.resetFailed {
+		s.logger.Warn("task failed before, pass --resetFailed to run it again")
+		s.state = TaskState_Failed
+		close(s.done)
+	}
+	return nil
+}
+
+// saveProgress stores the task progress with the current state.
+func (s *TokenSwapper) saveProgress() {
+	s.progress.State = s.State()
+	s.progress.PovLastVolume = ""
+	if s.participation.lastVolume != nil {
+		s.progress.PovLastVolume = s.participation.lastVolume.String()
+	}
+	s.progress.PovOwnAmount = s.participation.ownAmount
+	err := s.store.Set(progressKey(s.swapTask.ID), s.progress)
+	if err != nil {
+		s.logger.Warn("fail to save task progress", zap.Error(err))
+	}
+}
+
+// recordFill adds a successful swap to the task progress. With a pool depth
+// cap, what the slice wanted and did not fill is carried to the next slices.
+func (s *TokenSwapper) recordFill(status *SwapStatus, now time.Time) {
+	s.progress.Slices++
+	s.progress.Filled[status.Side] += status.Amount
+	s.progress.LastSwap = now.Unix()
+	s.participation.ownAmount += status.Amount
+	if s.depthWanted > 0 {
+		s.progress.DepthCarry = 0
+		if s.depthWanted > status.Amount {
+			s.progress.DepthCarry = s.depthWanted - status.Amount
+		}
+		s.depthWanted = 0
+	}
+}
+
+// ResumeAt returns when the first run should happen so a restart does not
+// run a slice again before the interval since the last run has passed. It is
+// zero when the task can run right away.
+func (s *TokenSwapper) ResumeAt(now time.Time) time.Time {
+	if s.progress.LastRun == 0 || s.swapTask.interval == 0 {
+		return time.Time{}
+	}
+	next := time.Unix(s.progress.LastRun, 0).Add(s.swapTask.interval)
+	if !next.After(now) {
+		return time.Time{}
+	}
+	return next
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/big"
 
 	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
@@ -15,21 +16,42 @@ import (
 	"github.com/gopartyparrot/goparrot-twap/config"
 )
 
+const (
+	// Raydium amm v4 trade fee is 0.25%
+	raydiumFeeNumerator   = 25
+	raydiumFeeDenominator = 10000
+)
+
 type RaydiumSwap struct {
 	clientRPC *rpc.Client
+	RPCWs     string
 	account   solana.PrivateKey
 }
 
-func (s *RaydiumSwap) Swap(
-	ctx context.Context,
-	pool *config.RaydiumPoolConfig,
-	amount uint64,
-	fromToken string,
-	fromAccount solana.PublicKey,
-	toToken string,
-	toAccount solana.PublicKey,
-) (*solana.Signature, error) {
+// PoolReserves are the balances of the pool coin and pc token accounts.
+type PoolReserves struct {
+	CoinMint   solana.PublicKey
+	CoinAmount uint64
+	PcMint     solana.PublicKey
+	PcAmount   uint64
+}
+
+// Reserve returns the pool reserve held for the given token mint.
+func (r *PoolReserves) Reserve(mint string) (uint64, bool) {
+	// The pool holds wrapped SOL when a pair is configured with native SOL
+	if mint == config.NativeSOL {
+		mint = config.WrappedSOL
+	}
+	switch mint {
+	case r.CoinMint.String():
+		return r.CoinAmount, true
+	case r.PcMint.String():
+		return r.PcAmount, true
+	}
+	return 0, false
+}
 
+func (s *RaydiumSwap) GetPoolReserves(ctx context.Context, pool *config.RaydiumPoolConfig) (*PoolReserves, error) {
 	res, err := s.clientRPC.GetMultipleAccounts(
 		ctx,
 		solana.MustPublicKeyFromBase58(pool.PoolCoinTokenAccount),
@@ -38,6 +60,9 @@ func (s *RaydiumSwap) Swap(
 	if err != nil {
 		return nil, err
 	}
+	if res.Value[0] == nil || res.Value[1] == nil {
+		return nil, errors.New("pool token accounts not found")
+	}
 
 	var poolCoinBalance token.Account
 	err = bin.NewBinDecoder(res.Value[0].Data.GetBinary()).Decode(&poolCoinBalance)
@@ -51,15 +76,78 @@ func (s *RaydiumSwap) Swap(
 		return nil, err
 	}
 
-	denominator := poolCoinBalance.Amount + amount
-	minimumOutAmount := poolPcBalance.Amount * amount / denominator
+	return &PoolReserves{
+		CoinMint:   poolCoinBalance.Mint,
+		CoinAmount: poolCoinBalance.Amount,
+		PcMint:     poolPcBalance.Mint,
+		PcAmount:   poolPcBalance.Amount,
+	}, nil
+}
+
+// SwapQuote is the expected result of swapping InAmount on a pool.
+type SwapQuote struct {
+	InAmount         uint64
+	ExpectedOut      uint64
+	MinimumOutAmount uint64
+}
+
+// Quote computes the output of swapping amount of fromToken from the pool
+// reserves, with the pool fees and a 2% slippage for the minimum output.
+func (s *RaydiumSwap) Quote(
+	ctx context.Context,
+	pool *config.RaydiumPoolConfig,
+	amount uint64,
+	fromToken string,
+	toToken string,
+) (*SwapQuote, error) {
+
+	reserves, err := s.GetPoolReserves(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	reserveIn, ok := reserves.Reserve(fromToken)
+	if !ok {
+		return nil, ErrPoolMintMismatch
+	}
+	reserveOut, ok := reserves.Reserve(toToken)
+	if !ok {
+		return nil, ErrPoolMintMismatch
+	}
+
+	// out = reserveOut * amountWithFee / (reserveIn + amountWithFee)
+	amountWithFee := new(big.Int).SetUint64(amount)
+	amountWithFee.Mul(amountWithFee, big.NewInt(raydiumFeeDenominator-raydiumFeeNumerator))
+	amountWithFee.Div(amountWithFee, big.NewInt(raydiumFeeDenominator))
+	denominator := new(big.Int).SetUint64(reserveIn)
+	denominator.Add(denominator, amountWithFee)
+	expectedOut := new(big.Int).SetUint64(reserveOut)
+	expectedOut.Mul(expectedOut, amountWithFee)
+	expectedOut.Div(expectedOut, denominator)
+
+	quote := SwapQuote{
+		InAmount:    amount,
+		ExpectedOut: expectedOut.Uint64(),
+	}
 	// slippage 2%
-	minimumOutAmount = minimumOutAmount * 98 / 100
+	quote.MinimumOutAmount = quote.ExpectedOut * 98 / 100
 
-	if minimumOutAmount <= 0 {
+	if quote.MinimumOutAmount <= 0 {
 		return nil, errors.New("min swap output amount must be grater then zero, try to swap a bigger amount")
 	}
+	return &quote, nil
+}
+
+func (s *RaydiumSwap) Swap(
+	ctx context.Context,
+	pool *config.RaydiumPoolConfig,
+	quote *SwapQuote,
+	fromToken string,
+	fromAccount solana.PublicKey,
+	toToken string,
+	toAccount solana.PublicKey,
+) (*solana.Signature, error) {
 
+	amount := quote.InAmount
 	instrs := []solana.Instruction{}
 	signers := []solana.PrivateKey{s.account}
 	tempAccount := solana.NewWallet()
@@ -111,7 +199,7 @@ func (s *RaydiumSwap) Swap(
 
 	instrs = append(instrs, NewRaydiumSwapInstruction(
 		amount,
-		minimumOutAmount,
+		quote.MinimumOutAmount,
 		solana.TokenProgramID,
 		solana.MustPublicKeyFromBase58(pool.AmmId),
 		solana.MustPublicKeyFromBase58(pool.AmmAuthority),
@@ -145,12 +233,102 @@ func (s *RaydiumSwap) Swap(
 		instrs = append(instrs, closeInst)
 	}
 
-	sig, err := ExecuteInstructions(ctx, s.clientRPC, signers, instrs...)
+	// Wait for the confirmation so the balances are up to date for the next swap
+	// The signature is also returned when the sent transaction failed
+	return ExecuteInstructionsAndWaitConfirm(ctx, s.clientRPC, s.RPCWs, signers, instrs...)
+}
+
+/** Accounts  **/
+
+// AmmInfo is the Raydium liquidity pool v4 amm account.
+type AmmInfo struct {
+	Status             uint64
+	Nonce              uint64
+	OrderNum           uint64
+	Depth              uint64
+	CoinDecimals       uint64
+	PcDecimals         uint64
+	State              uint64
+	ResetFlag          uint64
+	MinSize            uint64
+	VolMaxCutRatio     uint64
+	AmountWave         uint64
+	CoinLotSize        uint64
+	PcLotSize          uint64
+	MinPriceMultiplier uint64
+	MaxPriceMultiplier uint64
+	SysDecimalValue    uint64
+	// Fees
+	MinSeparateNumerator   uint64
+	MinSeparateDenominator uint64
+	TradeFeeNumerator      uint64
+	TradeFeeDenominator    uint64
+	PnlNumerator           uint64
+	PnlDenominator         uint64
+	SwapFeeNumerator       uint64
+	SwapFeeDenominator     uint64
+	// Output data, swap amounts are cumulative since the pool creation
+	NeedTakePnlCoin     uint64
+	NeedTakePnlPc       uint64
+	TotalPnlPc          uint64
+	TotalPnlCoin        uint64
+	PoolOpenTime        uint64
+	PunishPcAmount      uint64
+	PunishCoinAmount    uint64
+	OrderbookToInitTime uint64
+	SwapCoinInAmount    bin.Uint128
+	SwapPcOutAmount     bin.Uint128
+	SwapCoin2PcFee      uint64
+	SwapPcInAmount      bin.Uint128
+	SwapCoinOutAmount   bin.Uint128
+	SwapPc2CoinFee      uint64
+	// Accounts
+	PoolCoinTokenAccount solana.PublicKey
+	PoolPcTokenAccount   solana.PublicKey
+	CoinMint             solana.PublicKey
+	PcMint               solana.PublicKey
+	LpMint               solana.PublicKey
+	AmmOpenOrders        solana.PublicKey
+	SerumMarket          solana.PublicKey
+	SerumProgramId       solana.PublicKey
+	AmmTargetOrders      solana.PublicKey
+	PoolWithdrawQueue    solana.PublicKey
+	PoolTempLpTokenAcc   solana.PublicKey
+	AmmOwner             solana.PublicKey
+	LpAmount             uint64
+	ClientOrderId        uint64
+	Padding              [2]uint64
+}
+
+// SwapVolume returns the cumulative amount of the given mint swapped in and
+// out of the pool.
+func (a *AmmInfo) SwapVolume(mint string) (*big.Int, bool) {
+	if mint == config.NativeSOL {
+		mint = config.WrappedSOL
+	}
+	switch mint {
+	case a.CoinMint.String():
+		return new(big.Int).Add(a.SwapCoinInAmount.BigInt(), a.SwapCoinOutAmount.BigInt()), true
+	case a.PcMint.String():
+		return new(big.Int).Add(a.SwapPcInAmount.BigInt(), a.SwapPcOutAmount.BigInt()), true
+	}
+	return nil, false
+}
+
+func (s *RaydiumSwap) GetAmmInfo(ctx context.Context, pool *config.RaydiumPoolConfig) (*AmmInfo, error) {
+	res, err := s.clientRPC.GetAccountInfo(ctx, solana.MustPublicKeyFromBase58(pool.AmmId))
 	if err != nil {
 		return nil, err
 	}
-
-	return sig, nil
+	if res == nil || res.Value == nil {
+		return nil, errors.New("amm account not found")
+	}
+	var amm AmmInfo
+	err = bin.NewBinDecoder(res.Value.Data.GetBinary()).Decode(&amm)
+	if err != nil {
+		return nil, err
+	}
+	return &amm, nil
 }
 
 /** Instructions  **/
@@ -0,0 +1,94 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidRebalanceTarget = errors.New("rebalance target must be between 0 and 100")
+)
+
+// Rebalance trades toward RebalanceTarget percent of the wallet value in the
+// pair base token (pool ToToken), the rest being in the quote token (pool
+// FromToken). Values use the pool price, each swap is at most Amount of quote
+// token value and nothing is done while the drift is within RebalanceTolerance.
+func (s *TokenSwapper) Rebalance(ctx context.Context) error {
+	quoteToken := s.swapTask.pool.FromToken
+	baseToken := s.swapTask.pool.ToToken
+	quoteInfo := s.tokens[quoteToken]
+	baseInfo := s.tokens[baseToken]
+	quoteBalance := s.balance(s.tokenAccounts[quoteToken])
+	baseBalance := s.balance(s.tokenAccounts[baseToken])
+
+	price, err := s.GetPoolPrice(ctx)
+	if err != nil {
+		s.logger.Warn("fail to get pool price", zap.Error(err))
+		return err
+	}
+
+	baseValue := baseInfo.ToFloat(baseBalance) * price
+	total := baseValue + quoteInfo.ToFloat(quoteBalance)
+	if total == 0 {
+		s.logger.Warn("nothing to rebalance, both balances are empty")
+		return ErrFromBalanceNotEnough
+	}
+	drift := baseValue/total - s.swapTask.RebalanceTarget/100
+	if math.Abs(drift)*100 <= s.swapTask.RebalanceTolerance {
+		s.logger.Info("balances within rebalance tolerance, no swap",
+			zap.Float64("basePercent", baseValue/total*100),
+			zap.Float64("target", s.swapTask.RebalanceTarget),
+		)
+		return nil
+	}
+
+	value := math.Min(math.Abs(drift)*total, s.swapTask.Amount)
+	status := SwapStatus{
+		Pair:  s.swapTask.Pair,
+		Side:  SwapSide_Buy,
+		Price: price,
+	}
+	amount := quoteInfo.FromFloat(value)
+	fromBalance := quoteBalance
+	if drift > 0 {
+		status.Side = SwapSide_Sell
+		amount = baseInfo.FromFloat(value / price)
+		fromBalance = baseBalance
+	}
+	s.logger.Info("rebalancing "+baseInfo.Symbol+"/"+quoteInfo.Symbol,
+		zap.Float64("basePercent", baseValue/total*100),
+		zap.Float64("target", s.swapTask.RebalanceTarget),
+		zap.String("side", string(status.Side)),
+		zap.Uint64("amount", amount),
+	)
+
+	if amount > fromBalance {
+		amount = fromBalance
+	}
+	fromToken, _ := s.sideTokens(status.Side)
+	if s.hasBudget(status.Side) {
+		amount, err = s.CapToBudget(s.tokens[fromToken], amount)
+		if err != nil {
+			return err
+		}
+	}
+	amount, err = s.CapToFeeReserve(ctx, fromToken, amount, fromBalance)
+	if err != nil {
+		return err
+	}
+	if amount == 0 {
+		s.logger.Info("swap amount is zero, skipping swap")
+		return nil
+	}
+
+	// A failed swap is saved in the store and tried again next interval
+	err = s.ExecuteSwap(ctx, amount, &status)
+	if stopsSwaps(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package swap
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrFeeReserveReached = errors.New("SOL balance is at the fee reserve")
+)
+
+const (
+	// signatures paid by a swap (wallet and temporary WSOL account) and a transfer
+	reserveSignatures = 3
+	defaultSolReserve = 0.01
+)
+
+// FeeReserve returns the lamports that must stay in the wallet: SolReserve,
+// the rent of the temporary WSOL account of a swap and the transaction fees.
+func (s *TokenSwapper) FeeReserve(ctx context.Context) (uint64, error) {
+	rent, err := s.clientRPC.GetMinimumBalanceForRentExemption(
+		ctx,
+		config.TokenAccountSize,
+		rpc.CommitmentConfirmed,
+	)
+	if err != nil {
+		return 0, err
+	}
+	recent, err := s.clientRPC.GetRecentBlockhash(ctx, rpc.CommitmentFinalized)
+	if err != nil {
+		return 0, err
+	}
+	fees := recent.Value.FeeCalculator.LamportsPerSignature * reserveSignatures
+	solInfo := s.tokens[config.NativeSOL]
+	return solInfo.FromFloat(s.swapTask.SolReserve) + rent + fees, nil
+}
+
+// CapToFeeReserve limits a swap from native SOL so the wallet keeps the fee
+// reserve. It warns and notifies once when the balance left gets below twice
+// the reserve, and returns ErrFeeReserveReached when nothing can be swapped.
+// That is also notified once, until the balance is above the reserve again.
+func (s *TokenSwapper) CapToFeeReserve(ctx context.Context, fromToken string, amount uint64, fromBalance uint64) (uint64, error) {
+	if fromToken != config.NativeSOL {
+		return amount, nil
+	}
+	reserve, err := s.FeeReserve(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	solInfo := s.tokens[config.NativeSOL]
+	if fromBalance <= reserve {
+		s.notifyReserveReached("SOL balance is at the fee reserve, swaps are stopped",
+			zap.Float64("balance", solInfo.ToFloat(fromBalance)),
+			zap.Float64("reserve", solInfo.ToFloat(reserve)),
+		)
+		return 0, ErrFeeReserveReached
+	}
+	s.progress.ReserveReachedNotified = false
+	if amount > fromBalance-reserve {
+		amount = fromBalance - reserve
+	}
+
+	left := fromBalance - amount
+	if left < 2*reserve {
+		if !s.progress.LowReserveNotified {
+			s.notify("SOL balance is getting low, top up the wallet to keep swapping",
+				zap.Float64("balanceAfterSwap", solInfo.ToFloat(left)),
+				zap.Float64("reserve", solInfo.ToFloat(reserve)),
+			)
+		}
+		s.progress.LowReserveNotified = true
+	} else {
+		s.progress.LowReserveNotified = false
+	}
+	return amount, nil
+}
+
+// notifyReserveReached notifies that swaps are stopped by the fee reserve,
+// only the first time until the reserve is not reached anymore.
+func (s *TokenSwapper) notifyReserveReached(message string, fields ...zap.Field) {
+	if s.progress.ReserveReachedNotified {
+		s.logger.Info(message, fields...)
+		return
+	}
+	s.notify(message, fields...)
+	s.progress.ReserveReachedNotified = true
+}
@@ -0,0 +1,281 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+	"go.uber.org/zap"
+)
+
+var (
+	// Retryable errors, the swap is quoted and sent again in the same interval
+	ErrRPCTimeout       = errors.New("rpc timeout or unavailable")
+	ErrBlockhashExpired = errors.New("blockhash expired")
+	ErrSlippageExceeded = errors.New("slippage exceeded")
+	// Fatal errors, the task can not swap anymore
+	ErrAccountNotFound = errors.New("account not found")
+	ErrWrongPool       = errors.New("wrong pool")
+
+	// ErrInsufficientFunds is returned when the wallet has not enough SOL to
+	// pay the fees, the task is paused like with a from balance too low
+	ErrInsufficientFunds = errors.New("not enough SOL to pay the transaction fees")
+
+	// ErrUnconfirmed is returned when the wait for a sent transaction ended
+	// before its outcome was known, it may still land so it is not retried
+	ErrUnconfirmed = errors.New("transaction not confirmed")
+
+	ErrInvalidRetries = errors.New("retry backoff must be a duration (s, m, h)")
+
+	errQuoteRejected = errors.New("quote rejected")
+)
+
+const (
+	defaultRetryBackoff = "2s"
+	defaultSwapRetries  = 2
+	sentCheckTimeout    = 10 * time.Second
+	// an unconfirmed swap unknown to the cluster this long after it was saved
+	// has an expired blockhash and can not land anymore
+	unconfirmedDeadAfter = 2 * confirmTimeout
+)
+
+// SwapAttempt is one try to swap in an interval, they are saved in the
+// status of the swap.
+type SwapAttempt struct {
+	Date       string
+	TxID       string `json:",omitempty"`
+	Amount     uint64
+	MinimumOut uint64 `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	Retryable  bool   `json:",omitempty"`
+}
+
+func newSwapAttempt(status *SwapStatus, sig *solana.Signature, err error) SwapAttempt {
+	attempt := SwapAttempt{
+		Date:       time.Now().UTC().Format(time.UnixDate),
+		Amount:     status.Amount,
+		MinimumOut: status.MinimumOut,
+	}
+	if sig != nil {
+		attempt.TxID = sig.String()
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.Retryable = IsRetryable(err)
+	}
+	return attempt
+}
+
+// classifiedError keeps the original error and adds its class.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.class.Error() + ": " + e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// ClassifyError wraps err with the retryable or fatal error it matches, the
+// error is returned as is when it is unknown.
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	class := errorClass(err)
+	if class == nil || errors.Is(err, class) {
+		return err
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+func errorClass(err error) error {
+	switch {
+	case errors.Is(err, ErrPoolMintMismatch),
+		errors.Is(err, ErrSwapPoolNotFound),
+		errors.Is(err, ErrWrongPool):
+		return ErrWrongPool
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrRPCTimeout
+	}
+
+	var programErr *ProgramError
+	if errors.As(err, &programErr) {
+		return programErr.class
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrRPCTimeout
+	}
+	var httpErr *jsonrpc.HTTPError
+	if errors.As(err, &httpErr) && (httpErr.Code == 429 || httpErr.Code >= 500) {
+		return ErrRPCTimeout
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "blockhash not found"),
+		strings.Contains(msg, "blockhashnotfound"),
+		strings.Contains(msg, "block height exceeded"):
+		return ErrBlockhashExpired
+	case strings.Contains(msg, "programaccountnotfound"),
+		strings.Contains(msg, "could not find account"):
+		return ErrAccountNotFound
+	// The fee payer has no SOL
+	case strings.Contains(msg, "accountnotfound"),
+		strings.Contains(msg, "no record of a prior credit"),
+		strings.Contains(msg, "insufficientfundsforfee"),
+		strings.Contains(msg, "insufficient funds for fee"):
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// checkSent makes sure the sent swap that failed with err can not land
+// before it is sent again. Failed transactions and the ones dropped with their
+// blockhash are dead, for other errors the status of the transaction is
+// checked: the swap succeeded when it landed, and err becomes ErrUnconfirmed
+// when it is unknown so the swap is not sent twice.
+func (s *TokenSwapper) checkSent(sig solana.Signature, err error) error {
+	var txErr *TransactionError
+	if errors.Is(err, ErrBlockhashExpired) || errors.As(err, &txErr) {
+		return err
+	}
+	// The context of the swap may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), sentCheckTimeout)
+	defer cancel()
+	statuses, statusErr := s.clientRPC.GetSignatureStatuses(ctx, true, sig)
+	if statusErr == nil && len(statuses.Value) == 1 && statuses.Value[0] != nil {
+		if statuses.Value[0].Err == nil {
+			s.logger.Warn("swap transaction landed despite the error", zap.Stringer("txID", sig), zap.Error(err))
+			return nil
+		}
+		return ClassifyError(&TransactionError{Err: DecodeTransactionError(statuses.Value[0].Err, nil)})
+	}
+	if errors.Is(err, ErrUnconfirmed) {
+		return err
+	}
+	return &classifiedError{class: ErrUnconfirmed, err: err}
+}
+
+// withRetries calls read until it succeeds, up to SwapRetries more times with
+// the doubling backoff of the swaps. Reads change nothing so every error is
+// tried again.
+func (s *TokenSwapper) withRetries(ctx context.Context, what string, read func() error) error {
+	backoff := s.swapTask.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := read()
+		if err == nil || attempt > s.swapTask.SwapRetries {
+			return err
+		}
+		s.logger.Warn("fail to "+what+", retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// resolveUnconfirmed checks the swaps of the task saved as unconfirmed. The
+// ones that landed are counted as filled like a successful swap, the ones
+// that failed or expired are saved as failed.
+func (s *TokenSwapper) resolveUnconfirmed(ctx context.Context, now time.Time) {
+	for _, key := range s.store.Keys(s.swapTask.ID + "_") {
+		var status SwapStatus
+		_, err := s.store.Get(key, &status)
+		if err != nil || !status.Unconfirmed || status.Task != s.swapTask.ID {
+			continue
+		}
+		sig, err := solana.SignatureFromBase58(status.TxID)
+		if err != nil {
+			continue
+		}
+		statuses, err := s.clientRPC.GetSignatureStatuses(ctx, true, sig)
+		if err != nil && err != rpc.ErrNotFound {
+			s.logger.Warn("fail to check unconfirmed swap", zap.String("txID", status.TxID), zap.Error(err))
+			continue
+		}
+		var txStatus *rpc.SignatureStatusesResult
+		if statuses != nil && len(statuses.Value) == 1 {
+			txStatus = statuses.Value[0]
+		}
+
+		switch {
+		case txStatus != nil && txStatus.ConfirmationStatus == rpc.ConfirmationStatusProcessed:
+			continue
+		case txStatus != nil && txStatus.Err == nil:
+			s.logger.Info("unconfirmed swap landed", zap.String("txID", status.TxID), zap.Uint64("amount", status.Amount))
+			status.ErrLogs = ""
+			// The landed swap filled the depth carry first
+			s.depthWanted = s.progress.DepthCarry
+			s.recordFill(&status, now)
+			if s.swapTask.hasExitTrigger() {
+				err = s.recordLateExitSold(status.Amount)
+				if err != nil {
+					s.logger.Warn("fail to save exit sold amount", zap.Error(err))
+				}
+			}
+		case txStatus != nil:
+			status.ErrLogs = ErrorLogs(&TransactionError{Err: DecodeTransactionError(txStatus.Err, nil)})
+		default:
+			// Not known by the cluster once its blockhash expired, it is dropped
+			date, err := time.Parse(time.UnixDate, status.Date)
+			if err == nil && now.Sub(date) < unconfirmedDeadAfter {
+				continue
+			}
+		}
+		status.Unconfirmed = false
+		err = s.store.Set(key, status)
+		if err != nil {
+			s.logger.Warn("fail to save swap", zap.Error(err))
+		}
+	}
+}
+
+// recordLateExitSold adds a swap that landed after its run to the exit sold
+// amount.
+func (s *TokenSwapper) recordLateExitSold(amount uint64) error {
+	state, err := s.GetExitState()
+	if err != nil {
+		return err
+	}
+	return s.RecordExitSold(state, amount)
+}
+
+// IsRetryable tells if a swap that failed with err can be tried again.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRPCTimeout) ||
+		errors.Is(err, ErrBlockhashExpired) ||
+		errors.Is(err, ErrSlippageExceeded)
+}
+
+// IsFatal tells if err stops the task.
+func IsFatal(err error) bool {
+	return errors.Is(err, ErrAccountNotFound) || errors.Is(err, ErrWrongPool)
+}
+
+// stopsSwaps tells if a failed swap returns err to the run to fail or pause
+// the task, other errors are tried again next interval.
+func stopsSwaps(err error) bool {
+	return IsFatal(err) || errors.Is(err, ErrInsufficientFunds)
+}
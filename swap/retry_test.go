@@ -0,0 +1,75 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantClass     error
+		wantRetryable bool
+		wantFatal     bool
+	}{
+		{name: "no error"},
+		{name: "unknown error", err: errors.New("something else")},
+		{name: "context deadline", err: fmt.Errorf("get quote: %w", context.DeadlineExceeded), wantClass: ErrRPCTimeout, wantRetryable: true},
+		{name: "rpc rate limit", err: jsonrpc.NewHTTPError(429, errors.New("too many requests")), wantClass: ErrRPCTimeout, wantRetryable: true},
+		{name: "rpc unavailable", err: jsonrpc.NewHTTPError(503, errors.New("unavailable")), wantClass: ErrRPCTimeout, wantRetryable: true},
+		{name: "rpc bad request", err: jsonrpc.NewHTTPError(400, errors.New("bad request"))},
+		{name: "blockhash not found", err: errors.New("Transaction simulation failed: Blockhash not found"), wantClass: ErrBlockhashExpired, wantRetryable: true},
+		{name: "block height exceeded", err: errors.New("transaction expired: block height exceeded"), wantClass: ErrBlockhashExpired, wantRetryable: true},
+		{
+			name:          "slippage",
+			err:           &TransactionError{Err: &InstructionError{Index: 2, Err: ErrRaydiumExceededSlippage}},
+			wantClass:     ErrSlippageExceeded,
+			wantRetryable: true,
+		},
+		{
+			name:      "fee payer without SOL",
+			err:       errors.New("Transaction simulation failed: Attempt to debit an account but found no record of a prior credit."),
+			wantClass: ErrInsufficientFunds,
+		},
+		{name: "program account not found", err: errors.New("ProgramAccountNotFound"), wantClass: ErrAccountNotFound, wantFatal: true},
+		{name: "uninitialized token account", err: &TransactionError{Err: ErrTokenUninitializedState}, wantClass: ErrAccountNotFound, wantFatal: true},
+		{name: "wrong pool", err: &TransactionError{Err: ErrRaydiumInvalidCoinVault}, wantClass: ErrWrongPool, wantFatal: true},
+		{name: "pool not found", err: ErrSwapPoolNotFound, wantClass: ErrWrongPool, wantFatal: true},
+		{
+			name:      "unconfirmed is not retried",
+			err:       fmt.Errorf("%w: %v", ErrUnconfirmed, context.DeadlineExceeded),
+			wantClass: ErrUnconfirmed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyError(tt.err)
+			if tt.err == nil {
+				if got != nil {
+					t.Errorf("ClassifyError(nil) = %v", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("ClassifyError() = %v, the original error is lost", got)
+			}
+			if tt.wantClass != nil && !errors.Is(got, tt.wantClass) {
+				t.Errorf("ClassifyError() = %v, want class %v", got, tt.wantClass)
+			}
+			if tt.wantClass == nil && got != tt.err {
+				t.Errorf("ClassifyError() = %v, want the error unchanged", got)
+			}
+			if IsRetryable(got) != tt.wantRetryable {
+				t.Errorf("IsRetryable(%v) = %v, want %v", got, IsRetryable(got), tt.wantRetryable)
+			}
+			if IsFatal(got) != tt.wantFatal {
+				t.Errorf("IsFatal(%v) = %v, want %v", got, IsFatal(got), tt.wantFatal)
+			}
+		})
+	}
+}
@@ -2,12 +2,13 @@ package swap
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/programs/token"
 	"github.com/gagliardetto/solana-go/rpc"
-	confirm "github.com/gagliardetto/solana-go/rpc/sendAndConfirmTransaction"
 	"github.com/gagliardetto/solana-go/rpc/ws"
 	"github.com/gopartyparrot/goparrot-twap/config"
 )
@@ -150,7 +151,7 @@ func ExecuteInstructions(
 		rpc.CommitmentFinalized,
 	)
 	if err != nil {
-		return nil, err
+		return nil, decodeSendError(err, tx)
 	}
 
 	return &sig, nil
@@ -174,15 +175,108 @@ func ExecuteInstructionsAndWaitConfirm(
 		return nil, err
 	}
 
-	sig, err := confirm.SendAndConfirmTransaction(
+	defer clientWS.Close()
+
+	sig, err := clientRPC.SendTransactionWithOpts(
 		ctx,
-		clientRPC,
-		clientWS,
 		tx,
+		false,
+		rpc.CommitmentFinalized,
 	)
 	if err != nil {
-		return nil, err
+		return nil, decodeSendError(err, tx)
 	}
 
-	return &sig, nil
+	// The transaction was sent, the signature is returned with the errors as
+	// its fee may have been paid
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+	return &sig, waitConfirm(ctx, clientRPC, clientWS, sig, tx)
+}
+
+const (
+	confirmPollInterval = 5 * time.Second
+	// confirmTimeout is longer than a blockhash stays valid, a dropped
+	// transaction is known before it
+	confirmTimeout = 90 * time.Second
+)
+
+// waitConfirm waits until the transaction is finalized. The status is also
+// polled so a failed websocket or a transaction dropped by the cluster does
+// not block forever, it returns ErrUnconfirmed when ctx is done first.
+func waitConfirm(
+	ctx context.Context,
+	clientRPC *rpc.Client,
+	clientWS *ws.Client,
+	sig solana.Signature,
+	tx *solana.Transaction,
+) error {
+	confirmed := make(chan error, 1)
+	sub, err := clientWS.SignatureSubscribe(sig, rpc.CommitmentFinalized)
+	if err == nil {
+		defer sub.Unsubscribe()
+		go func() {
+			// Recv returns when the websocket is closed, on errors the
+			// status is left to the polling
+			got, err := sub.Recv()
+			if err != nil {
+				return
+			}
+			if got.Value.Err != nil {
+				confirmed <- &TransactionError{Err: DecodeTransactionError(got.Value.Err, tx)}
+				return
+			}
+			confirmed <- nil
+		}()
+	}
+
+	ticker := time.NewTicker(confirmPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-confirmed:
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrUnconfirmed, ctx.Err())
+		case <-ticker.C:
+			done, err := TransactionOutcome(ctx, clientRPC, sig, tx)
+			if done {
+				return err
+			}
+		}
+	}
+}
+
+// TransactionOutcome checks a sent transaction, done is false while it can
+// still land. Once done, err is nil when it was finalized, a TransactionError
+// when it failed and ErrBlockhashExpired when it was dropped.
+func TransactionOutcome(
+	ctx context.Context,
+	clientRPC *rpc.Client,
+	sig solana.Signature,
+	tx *solana.Transaction,
+) (bool, error) {
+	// The blockhash is checked first, a transaction not found after its
+	// blockhash expired can not land anymore
+	recent, err := clientRPC.GetFeeCalculatorForBlockhash(ctx, tx.Message.RecentBlockhash, rpc.CommitmentFinalized)
+	if err != nil {
+		return false, err
+	}
+	expired := recent == nil || recent.Value == nil
+
+	statuses, err := clientRPC.GetSignatureStatuses(ctx, false, sig)
+	if err != nil && err != rpc.ErrNotFound {
+		return false, err
+	}
+	if statuses != nil && len(statuses.Value) == 1 && statuses.Value[0] != nil {
+		status := statuses.Value[0]
+		if status.Err != nil {
+			return true, &TransactionError{Err: DecodeTransactionError(status.Err, tx)}
+		}
+		return status.ConfirmationStatus == rpc.ConfirmationStatusFinalized, nil
+	}
+	if expired {
+		return true, ErrBlockhashExpired
+	}
+	return false, nil
 }
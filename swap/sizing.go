@@ -0,0 +1,150 @@
+package swap
+
+import (
+	"context"
+	"time"
+
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"go.uber.org/zap"
+)
+
+// SliceAmount returns the amount of from token to swap in this interval.
+// The status PriceMultiplier scales the base amount before the pool depth
+// cap, and the notional conversion rate is saved in the status. The amount
+// never takes the from balance below BalanceFloor.
+func (s *TokenSwapper) SliceAmount(ctx context.Context, fromTokenInfo config.TokenInfo, fromBalance uint64, status *SwapStatus) (uint64, error) {
+	s.depthWanted = 0
+	amount, err := s.baseSliceAmount(ctx, fromTokenInfo, fromBalance, status)
+	if err != nil {
+		return 0, err
+	}
+	amount = uint64(float64(amount) * status.PriceMultiplier)
+	// Keep the carry for when there is something to swap again
+	if s.swapTask.DepthPercent > 0 && amount > 0 {
+		amount, err = s.CapToPoolDepth(ctx, amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.capToBalanceFloor(fromTokenInfo, amount, fromBalance), nil
+}
+
+func (s *TokenSwapper) capToBalanceFloor(fromTokenInfo config.TokenInfo, amount uint64, fromBalance uint64) uint64 {
+	floor := fromTokenInfo.FromFloat(s.swapTask.BalanceFloor)
+	if floor == 0 {
+		return amount
+	}
+	available := uint64(0)
+	if fromBalance > floor {
+		available = fromBalance - floor
+	}
+	if amount > available {
+		s.logger.Info("amount capped by balance floor",
+			zap.Uint64("balanceFloor", floor),
+			zap.Uint64("available", available),
+		)
+		amount = available
+	}
+	if s.swapTask.BalancePercent > 0 && amount < fromTokenInfo.FromFloat(s.swapTask.BalanceMinAmount) {
+		return 0
+	}
+	return amount
+}
+
+// CapToPoolDepth limits amount to DepthPercent of the pool from token reserve.
+// What is above the cap is carried over and added to the next slices, the
+// carry changes in recordFill so it is kept when the slice is not swapped.
+func (s *TokenSwapper) CapToPoolDepth(ctx context.Context, amount uint64) (uint64, error) {
+	reserves, err := s.raydiumSwap.GetPoolReserves(ctx, &s.swapTask.pool.RaydiumPoolConfig)
+	if err != nil {
+		return 0, err
+	}
+	reserveIn, ok := reserves.Reserve(s.swapTask.fromToken)
+	if !ok {
+		return 0, ErrPoolMintMismatch
+	}
+
+	wanted := amount + s.progress.DepthCarry
+	s.depthWanted = wanted
+	maxAmount := uint64(float64(reserveIn) * s.swapTask.DepthPercent / 100)
+	if wanted <= maxAmount {
+		return wanted, nil
+	}
+	s.logger.Info("amount capped by pool depth",
+		zap.Uint64("reserve", reserveIn),
+		zap.Uint64("maxAmount", maxAmount),
+		zap.Uint64("carry", wanted-maxAmount),
+	)
+	return maxAmount, nil
+}
+
+func (s *TokenSwapper) baseSliceAmount(ctx context.Context, fromTokenInfo config.TokenInfo, fromBalance uint64, status *SwapStatus) (uint64, error) {
+	if s.swapTask.BalancePercent > 0 {
+		amount := uint64(float64(fromBalance) * s.swapTask.BalancePercent / 100)
+		minAmount := fromTokenInfo.FromFloat(s.swapTask.BalanceMinAmount)
+		if amount < minAmount {
+			amount = minAmount
+		}
+		s.logger.Info("balance percent amount",
+			zap.Float64("balancePercent", s.swapTask.BalancePercent),
+			zap.Uint64("balance", fromBalance),
+			zap.Uint64("amount", amount),
+		)
+		return amount, nil
+	}
+
+	if s.swapTask.PovPercent > 0 {
+		amount, err := s.ParticipationAmount(ctx, fromTokenInfo)
+		if err != nil {
+			return 0, err
+		}
+		s.logger.Info("participation amount",
+			zap.Float64("povPercent", s.swapTask.PovPercent),
+			zap.Uint64("amount", amount),
+		)
+		return amount, nil
+	}
+
+	amount := s.swapTask.Amount
+	if s.swapTask.Notional > 0 {
+		rate, err := s.NotionalRate(ctx)
+		if err != nil {
+			return 0, err
+		}
+		status.NotionalRate = rate
+		amount = s.swapTask.Notional / rate
+		s.logger.Info("notional amount converted",
+			zap.Float64("notional", s.swapTask.Notional),
+			zap.Float64("rate", rate),
+			zap.Float64("amount", amount),
+		)
+	}
+
+	volumeWeight := s.VolumeWeight(ctx, time.Now())
+	if volumeWeight != 1 {
+		s.logger.Info("amount scaled by volume profile", zap.Float64("volumeWeight", volumeWeight))
+	}
+	return s.coalesceSlices(fromTokenInfo.FromFloat(amount*volumeWeight), fromBalance), nil
+}
+
+// coalesceSlices sizes a fixed or notional amount for the missed slices it
+// stands for, the balance and participation amounts already follow the pool
+// and the wallet. It is capped by the from balance, but not below one slice
+// so a balance too low still pauses the task.
+func (s *TokenSwapper) coalesceSlices(amount uint64, fromBalance uint64) uint64 {
+	if s.sliceSize <= 1 {
+		return amount
+	}
+	coalesced := amount * uint64(s.sliceSize)
+	if coalesced > fromBalance {
+		coalesced = fromBalance
+	}
+	if coalesced < amount {
+		coalesced = amount
+	}
+	s.logger.Info("coalescing missed slices",
+		zap.Int("slices", s.sliceSize),
+		zap.Uint64("amount", coalesced),
+	)
+	return coalesced
+}
@@ -4,23 +4,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gagliardetto/solana-go"
 	associatedtokenaccount "github.com/gagliardetto/solana-go/programs/associated-token-account"
-	"github.com/gagliardetto/solana-go/programs/token"
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/gopartyparrot/goparrot-twap/config"
-	"github.com/gopartyparrot/goparrot-twap/price"
-	"github.com/gopartyparrot/goparrot-twap/store"
+	"github.com/gopartyparrot/goparrot-twap/notify"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
-	ErrSwapPoolNotFound     = errors.New("swap pool not found for given pair")
-	ErrUpdateBalances       = errors.New("failed to update wallet balances")
-	ErrFromBalanceNotEnough = errors.New("from balance not enough for swap")
-	ErrStopAmountReached    = errors.New("stop amount reached, balance is full")
+	ErrSwapPoolNotFound        = errors.New("swap pool not found for given pair")
+	ErrUpdateBalances          = errors.New("failed to update wallet balances")
+	ErrFromBalanceNotEnough    = errors.New("from balance not enough for swap")
+	ErrStopAmountReached       = errors.New("stop amount reached, balance is full")
+	ErrInvalidDepthPercent     = errors.New("depth percent must be between 0 and 100")
+	ErrNoAmount                = errors.New("amount must be set, or notional or balancePercent when not using povPercent")
+	ErrInvalidBalancePct       = errors.New("balance percent must be between 0 and 100")
+	ErrInvalidVolatilityWindow = errors.New("volatility window must be a duration (s, m, h)")
+	ErrUnknownTaskMode         = errors.New("unknown task mode, can be twap, rebalance or grid")
+	ErrInvalidTransfer         = errors.New("transfer needs a transferThreshold or transferSweepAt, and a valid transferAddress or transferSplits, not both")
+	ErrNoTransferTokenAccount  = errors.New("transfer address has no token account, set transferCreateAccount to create it")
 )
 
 type SwapSide string
@@ -30,26 +37,103 @@ const (
 	SwapSide_Sell SwapSide = "sell"
 )
 
+type TaskMode string
+
+const (
+	TaskMode_Twap      TaskMode = "twap"
+	TaskMode_Rebalance TaskMode = "rebalance"
+	TaskMode_Grid      TaskMode = "grid"
+)
+
 type SwapStatus struct {
-	TxID    string
-	Pair    string
-	Date    string
-	Side    SwapSide
-	Amount  uint64
-	ErrLogs string `json:",omitempty"`
+	Task            string `json:",omitempty"`
+	TxID            string
+	Pair            string
+	Date            string
+	Side            SwapSide
+	Amount          uint64
+	MinimumOut      uint64  `json:",omitempty"`
+	Price           float64 `json:",omitempty"`
+	PriceMultiplier float64 `json:",omitempty"`
+	NotionalRate    float64 `json:",omitempty"`
+	AuctionPrice    float64 `json:",omitempty"`
+	ErrLogs         string  `json:",omitempty"`
+	// Unconfirmed is set while the sent transaction may still land
+	Unconfirmed bool `json:",omitempty"`
+	// Every try of the interval, the fields above are from the last one
+	Attempts []SwapAttempt `json:",omitempty"`
 }
 
 type SwapTaskConfig struct {
-	pair                 string
-	side                 SwapSide
-	amount               float64
-	stopAmount           float64
+	ID                     string
+	Interval               string
+	Mode                   TaskMode
+	Pair                   string
+	Side                   SwapSide
+	Amount                 float64
+	StopAmount             float64
+	TransferAddress        string
+	TransferThreshold      float64
+	TransferCreateAccount  bool
+	TransferPolicy         TransferPolicy
+	TransferRetain         float64
+	TransferChunk          float64
+	TransferSplits         []TransferSplit
+	TransferSweepAt        string
+	CleanupReturnAddress   string
+	CleanupCloseAccounts   bool
+	PriceThreshold         float64
+	PriceSource            PriceSource
+	PriceReference         float64
+	PriceBandPercent       float64
+	PriceMaxMultiplier     float64
+	PriceCeiling           float64
+	PriceFloor             float64
+	Notional               float64
+	NotionalCurrency       string
+	BalancePercent         float64
+	BalanceMinAmount       float64
+	BalanceFloor           float64
+	RebalanceTarget        float64
+	RebalanceTolerance     float64
+	GridLower              float64
+	GridUpper              float64
+	GridLevels             int
+	TrailingStopPercent    float64
+	TakeProfitPrice        float64
+	ExitAmount             float64
+	AuctionStartPrice      float64
+	AuctionFloorPrice      float64
+	AuctionStep            float64
+	AuctionStepInterval    string
+	MaxVolatility          float64
+	VolatilityWindow       string
+	DailyBudget            float64
+	TotalBudget            float64
+	SolReserve             float64
+	VolumeProfilePath      string
+	VolumeProfileSnapshots bool
+	PovPercent             float64
+	PovMinAmount           float64
+	DepthPercent           float64
+	MissedTickPolicy       MissedTickPolicy
+	MaxMissedTicks         int
+	SwapRetries            int
+	RetryBackoff           string
+	BreakerFailures        int
+	BreakerFees            uint64
+	BreakerCooldown        string
+
 	fromToken            string
 	toToken              string
-	transferAddress      string
-	transferTokenAccount solana.PublicKey
-	transferThreshold    float64
-	priceThreshold       float32
+	transferDestinations []transferDestination
+	cleanupReturnAccount solana.PublicKey
+	volumeProfile        *VolumeProfile
+	auctionStepInterval  time.Duration
+	volatilityWindow     time.Duration
+	interval             time.Duration
+	retryBackoff         time.Duration
+	breakerCooldown      time.Duration
 	pool                 config.PoolConfig
 }
 
@@ -61,42 +145,41 @@ type TokenSwapperConfig struct {
 	Tokens     map[string]config.TokenInfo
 	Pools      map[string]config.PoolConfig
 	Logger     *zap.Logger
+	Notifier   notify.Notifier
+	// ResetFailed runs again the tasks saved as failed
+	ResetFailed bool
 }
 
 type TokenSwapper struct {
-	clientRPC     *rpc.Client
-	RPCWs         string
-	store         *store.JSONStore
-	account       solana.PrivateKey
+	*Wallet
 	logger        *zap.Logger
-	raydiumSwap   *RaydiumSwap
-	tokens        map[string]config.TokenInfo
-	pools         map[string]config.PoolConfig
-	tokenBalances map[string]uint64
 	tokenAccounts map[string]solana.PublicKey
 	swapTask      SwapTaskConfig
+	participation participation
+	progress      TaskProgress
+	// number of interval slices the current slice stands for
+	sliceSize int
+	// amount the current slice should have swapped with the depth carry, the
+	// carry is only settled once the swap filled
+	depthWanted  uint64
+	running      int32
+	runMu        sync.Mutex
+	breakerReset int32
+
+	stateMu sync.Mutex
+	state   TaskState
+	done    chan struct{}
 }
 
-func (s *TokenSwapper) Init(
-	ctx context.Context,
-	pair string,
-	side SwapSide,
-	amount float64,
-	stopAmount float64,
-	transferAddress string,
-	transferThreshold float64,
-	priceThreshold float32,
-) error {
-
-	s.swapTask = SwapTaskConfig{
-		pair:              pair,
-		side:              side,
-		amount:            amount,
-		stopAmount:        stopAmount,
-		transferAddress:   transferAddress,
-		transferThreshold: transferThreshold,
-		priceThreshold:    priceThreshold,
+func (s *TokenSwapper) Init(ctx context.Context, task SwapTaskConfig) error {
+	if task.ID == "" {
+		task.ID = task.Pair
 	}
+	s.swapTask = task
+	s.logger = s.Wallet.logger.With(zap.String("task", task.ID))
+	pair := task.Pair
+	side := task.Side
+	var err error
 
 	for k, v := range s.pools {
 		if k == pair {
@@ -107,13 +190,176 @@ func (s *TokenSwapper) Init(
 		return ErrSwapPoolNotFound
 	}
 
-	s.swapTask.fromToken = s.swapTask.pool.FromToken
-	s.swapTask.toToken = s.swapTask.pool.ToToken
-	if side == SwapSide_Sell {
-		s.swapTask.fromToken = s.swapTask.pool.ToToken
-		s.swapTask.toToken = s.swapTask.pool.FromToken
+	s.swapTask.fromToken, s.swapTask.toToken = s.sideTokens(side)
+
+	if s.swapTask.PovPercent < 0 || s.swapTask.PovPercent >= 100 {
+		return ErrInvalidPovPercent
+	}
+
+	switch s.swapTask.PriceSource {
+	case PriceSource_Pool:
+	case PriceSource_CoinGecko, "":
+		if s.swapTask.needPrice() && s.swapTask.pool.CoinGeckoID == "" {
+			return ErrNoCoinGeckoID
+		}
+	default:
+		return ErrUnknownPriceSource
+	}
+
+	switch s.swapTask.Mode {
+	case TaskMode_Twap, "":
+	case TaskMode_Rebalance:
+		if s.swapTask.RebalanceTarget <= 0 || s.swapTask.RebalanceTarget >= 100 {
+			return ErrInvalidRebalanceTarget
+		}
+		if s.swapTask.Amount <= 0 {
+			return ErrNoAmount
+		}
+	case TaskMode_Grid:
+		if s.swapTask.GridLower <= 0 || s.swapTask.GridUpper <= s.swapTask.GridLower || s.swapTask.GridLevels < 2 {
+			return ErrInvalidGrid
+		}
+		if s.swapTask.Amount <= 0 {
+			return ErrNoAmount
+		}
+	default:
+		return ErrUnknownTaskMode
+	}
+
+	if s.swapTask.hasExitTrigger() && (s.swapTask.Side != SwapSide_Sell || s.swapTask.ExitAmount <= 0) {
+		return ErrInvalidExitTrigger
+	}
+
+	if s.swapTask.AuctionStartPrice > 0 {
+		interval, err := time.ParseDuration(s.swapTask.AuctionStepInterval)
+		if err != nil || interval <= 0 ||
+			(s.swapTask.Mode != TaskMode_Twap && s.swapTask.Mode != "") ||
+			s.swapTask.Side != SwapSide_Sell ||
+			s.swapTask.AuctionFloorPrice <= 0 ||
+			s.swapTask.AuctionFloorPrice >= s.swapTask.AuctionStartPrice ||
+			s.swapTask.AuctionStep <= 0 {
+			return ErrInvalidAuction
+		}
+		s.swapTask.auctionStepInterval = interval
+	}
+
+	if s.swapTask.VolatilityWindow == "" {
+		s.swapTask.VolatilityWindow = defaultVolatilityWindow
+	}
+	if s.swapTask.MaxVolatility > 0 {
+		window, err := time.ParseDuration(s.swapTask.VolatilityWindow)
+		if err != nil || window <= 0 {
+			return ErrInvalidVolatilityWindow
+		}
+		s.swapTask.volatilityWindow = window
+	}
+
+	if s.swapTask.Amount <= 0 && (s.swapTask.PovPercent > 0 || (s.swapTask.Notional <= 0 && s.swapTask.BalancePercent <= 0)) {
+		return ErrNoAmount
+	}
+	if s.swapTask.BalancePercent < 0 || s.swapTask.BalancePercent > 100 {
+		return ErrInvalidBalancePct
 	}
 
+	if s.swapTask.NotionalCurrency == "" {
+		s.swapTask.NotionalCurrency = "usd"
+	}
+	if s.swapTask.Notional > 0 && s.swapTask.PriceSource != PriceSource_Pool && s.tokens[s.swapTask.fromToken].CoinGeckoID == "" {
+		return ErrNoCoinGeckoID
+	}
+
+	if s.swapTask.PriceReference > 0 && s.swapTask.PriceBandPercent <= 0 {
+		return ErrInvalidPriceBand
+	}
+	if s.swapTask.PriceMaxMultiplier <= 0 {
+		s.swapTask.PriceMaxMultiplier = defaultPriceMaxMultiplier
+	}
+
+	if s.swapTask.SolReserve <= 0 {
+		s.swapTask.SolReserve = defaultSolReserve
+	}
+
+	if s.swapTask.DepthPercent < 0 || s.swapTask.DepthPercent > 100 {
+		return ErrInvalidDepthPercent
+	}
+
+	if s.swapTask.VolumeProfilePath != "" && s.swapTask.VolumeProfileSnapshots {
+		return ErrVolumeProfileConflict
+	}
+	if s.swapTask.VolumeProfilePath != "" {
+		s.swapTask.volumeProfile, err = LoadVolumeProfile(s.swapTask.VolumeProfilePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch s.swapTask.MissedTickPolicy {
+	case MissedTickPolicy_Coalesce, MissedTickPolicy_CatchUp:
+	case MissedTickPolicy_Skip, "":
+		s.swapTask.MissedTickPolicy = MissedTickPolicy_Skip
+	default:
+		return ErrUnknownMissedTickPolicy
+	}
+	if s.swapTask.MaxMissedTicks <= 0 {
+		s.swapTask.MaxMissedTicks = defaultMaxMissedTicks
+	}
+
+	// A negative number of retries disables them
+	switch {
+	case s.swapTask.SwapRetries == 0:
+		s.swapTask.SwapRetries = defaultSwapRetries
+	case s.swapTask.SwapRetries < 0:
+		s.swapTask.SwapRetries = 0
+	}
+	if s.swapTask.RetryBackoff == "" {
+		s.swapTask.RetryBackoff = defaultRetryBackoff
+	}
+	s.swapTask.retryBackoff, err = time.ParseDuration(s.swapTask.RetryBackoff)
+	if err != nil || s.swapTask.retryBackoff <= 0 {
+		return ErrInvalidRetries
+	}
+
+	if s.swapTask.BreakerCooldown != "" {
+		s.swapTask.breakerCooldown, err = time.ParseDuration(s.swapTask.BreakerCooldown)
+		if err != nil || s.swapTask.breakerCooldown <= 0 {
+			return ErrInvalidBreakerCooldown
+		}
+	}
+
+	if s.swapTask.Interval != "" {
+		interval, err := time.ParseDuration(s.swapTask.Interval)
+		if err != nil || interval <= 0 {
+			return ErrInvalidInterval
+		}
+		s.swapTask.interval = interval
+	}
+
+	err = s.validateTransfer()
+	if err != nil {
+		return err
+	}
+
+	err = s.loadProgress()
+	if err != nil {
+		return err
+	}
+	// An ended task does not swap anymore and its cleanup may have closed
+	// its accounts, they are not created again
+	if s.State().Ended() {
+		return nil
+	}
+
+	// The token accounts are created once the settings are valid
+	err = s.initTokenAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	return s.initTransfer(ctx)
+}
+
+// initTokenAccounts finds the token accounts of the pair in the wallet and
+// creates the missing ones.
+func (s *TokenSwapper) initTokenAccounts(ctx context.Context) error {
 	mints := []solana.PublicKey{
 		solana.MustPublicKeyFromBase58(s.swapTask.pool.FromToken),
 		solana.MustPublicKeyFromBase58(s.swapTask.pool.ToToken),
@@ -151,32 +397,12 @@ func (s *TokenSwapper) Init(
 		}
 	}
 	s.tokenAccounts = existingAccounts
-
-	err = s.UpdateTransferTokenAccount(ctx, transferAddress)
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func (s *TokenSwapper) UpdateTransferTokenAccount(ctx context.Context, ownerAddress string) error {
-	if ownerAddress == "" {
-		return nil
-	}
-
-	toTokenPK := solana.MustPublicKeyFromBase58(s.swapTask.toToken)
-	ownerPK := solana.MustPublicKeyFromBase58(ownerAddress)
-	existingAccounts, missingAccounts, err := GetTokenAccountsFromMints(ctx, *s.clientRPC, ownerPK, toTokenPK)
-	if err != nil {
-		return err
-	}
-	if len(missingAccounts) > 0 {
-		s.logger.Info("transfer address do not have a token account", zap.String("mint", s.swapTask.toToken))
-		return nil
-	}
-	s.swapTask.transferTokenAccount = existingAccounts[s.swapTask.toToken]
-	return nil
+// ID returns the task id, the pair unless set in the task config.
+func (s *TokenSwapper) ID() string {
+	return s.swapTask.ID
 }
 
 func (s *TokenSwapper) UpdateBalances(ctx context.Context) error {
@@ -188,74 +414,59 @@ func (s *TokenSwapper) UpdateBalances(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	for address, amount := range res {
-		s.tokenBalances[address] = amount
-	}
+	s.setBalances(res)
 	return nil
 }
 
-func (s *TokenSwapper) GetCurrentPrice(ctx context.Context) (float32, error) {
-	client := price.NewClient(nil)
-	res, err := client.SimplePrice([]string{s.swapTask.pool.CoinGeckoID}, []string{"usd"})
-	if err != nil {
-		return 0, err
-	}
-	price := *res
-	return price[s.swapTask.pool.CoinGeckoID]["usd"], nil
-}
+func (s *TokenSwapper) Start() error {
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
 
-func (s *TokenSwapper) TransferBalance(ctx context.Context, sourceAddress solana.PublicKey, amount uint64, destAddress solana.PublicKey) error {
-	transferTx, err := token.NewTransferInstruction(
-		amount,
-		sourceAddress,
-		destAddress,
-		s.account.PublicKey(),
-		[]solana.PublicKey{},
-	).ValidateAndBuild()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer cancel()
+
+	err := s.checkBreaker(time.Now())
 	if err != nil {
 		return err
 	}
-	sig, err := ExecuteInstructionsAndWaitConfirm(ctx, s.clientRPC, s.RPCWs, []solana.PrivateKey{s.account}, transferTx)
+
+	s.resolveUnconfirmed(ctx, time.Now())
+
+	err = s.withRetries(ctx, "update balances", func() error {
+		return s.UpdateBalances(ctx)
+	})
 	if err != nil {
-		s.logger.Warn("transfer amount failed, will try again in next interval", zap.Error(err))
-		return err
+		return &classifiedError{class: ErrUpdateBalances, err: err}
 	}
-	s.logger.Info("transfer balance success", zap.String("txID", sig.String()))
-	return nil
-}
 
-func (s *TokenSwapper) Start() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
-	defer cancel()
+	if s.swapTask.MaxVolatility > 0 {
+		paused, err := s.VolatilityPaused(ctx)
+		if err != nil {
+			s.logger.Warn("fail to check volatility", zap.Error(err))
+			return err
+		}
+		if paused {
+			return ErrVolatilityTooHigh
+		}
+	}
 
-	err := s.UpdateBalances(ctx)
-	if err != nil {
-		return ErrUpdateBalances
+	if s.swapTask.Mode == TaskMode_Rebalance {
+		return s.Rebalance(ctx)
 	}
 
 	fromToken := s.swapTask.fromToken
 	fromAddress := s.tokenAccounts[fromToken]
-	fromBalance := s.tokenBalances[fromAddress.String()]
+	fromBalance := s.balance(fromAddress)
 	fromTokenInfo := s.tokens[fromToken]
 
 	toToken := s.swapTask.toToken
 	toAddress := s.tokenAccounts[toToken]
-	toBalance := s.tokenBalances[toAddress.String()]
+	toBalance := s.balance(toAddress)
 	toTokenInfo := s.tokens[toToken]
 
-	amount := fromTokenInfo.FromFloat(s.swapTask.amount)
-	stopAmount := toTokenInfo.FromFloat(s.swapTask.stopAmount)
-	transferThreshold := toTokenInfo.FromFloat(s.swapTask.transferThreshold)
+	stopAmount := toTokenInfo.FromFloat(s.swapTask.StopAmount)
 
-	if transferThreshold > 0 && toBalance > transferThreshold && s.swapTask.transferAddress != "" {
-		s.logger.Info("transfer threshold reached, transfering "+toTokenInfo.Symbol+" to transferAddress",
-			zap.Float64("threshold", s.swapTask.transferThreshold),
-			zap.Uint64("transferAmount", toBalance),
-			zap.String("transferAddress", s.swapTask.transferAddress),
-			zap.String("transferTokenAcccount", s.swapTask.transferTokenAccount.String()),
-		)
-		s.TransferBalance(ctx, toAddress, toBalance, s.swapTask.transferTokenAccount)
-	}
+	s.TransferToAddress(ctx, toBalance)
 
 	if stopAmount > 0 && toBalance > stopAmount {
 		s.logger.Info("stop amount reached, stopping swap "+fromTokenInfo.Symbol+" to "+toTokenInfo.Symbol,
@@ -265,95 +476,268 @@ func (s *TokenSwapper) Start() error {
 		return ErrStopAmountReached
 	}
 
-	if amount > fromBalance {
-		s.logger.Warn("not enough balance to swap "+fromTokenInfo.Symbol+" to "+toTokenInfo.Symbol,
-			zap.Uint64("swapAmount", amount),
-			zap.Uint64("currentBalance", fromBalance),
-		)
-		return ErrFromBalanceNotEnough
+	if s.swapTask.Mode == TaskMode_Grid {
+		return s.Grid(ctx)
+	}
+
+	var exitState *ExitState
+	if s.swapTask.hasExitTrigger() {
+		exitState, err = s.CheckExitTrigger(ctx)
+		if err != nil {
+			s.logger.Warn("fail to check exit trigger", zap.Error(err))
+			return err
+		}
+		if !exitState.Triggered {
+			return nil
+		}
 	}
 
-	// Check if current price is
-	if s.swapTask.priceThreshold > 0 {
-		currentPrice, err := s.GetCurrentPrice(ctx)
+	priceMultiplier := 1.0
+	var currentPrice float64
+	if s.swapTask.needPrice() {
+		err = s.withRetries(ctx, "get current price", func() error {
+			currentPrice, err = s.GetCurrentPrice(ctx)
+			return err
+		})
 		if err != nil {
 			s.logger.Warn("fail to get current price", zap.Error(err))
 			return err
 		}
 
-		if s.swapTask.side == SwapSide_Sell && currentPrice < s.swapTask.priceThreshold {
+		if s.swapTask.Side == SwapSide_Sell && currentPrice < s.swapTask.PriceThreshold {
 			s.logger.Info("price still low (below priceThreshold). no need to sell",
-				zap.Float32("currentPrice", currentPrice),
-				zap.Float32("priceThreshold", s.swapTask.priceThreshold),
+				zap.Float64("currentPrice", currentPrice),
+				zap.Float64("priceThreshold", s.swapTask.PriceThreshold),
 			)
 			return nil
 		}
-		if s.swapTask.side == SwapSide_Buy && currentPrice > s.swapTask.priceThreshold {
+		if s.swapTask.Side == SwapSide_Buy && s.swapTask.PriceThreshold > 0 && currentPrice > s.swapTask.PriceThreshold {
 			s.logger.Info("price still high (above priceThreshold). no need to buy",
-				zap.Float32("currentPrice", currentPrice),
-				zap.Float32("priceThreshold", s.swapTask.priceThreshold),
+				zap.Float64("currentPrice", currentPrice),
+				zap.Float64("priceThreshold", s.swapTask.PriceThreshold),
+			)
+			return nil
+		}
+		if !s.swapTask.inPriceLimits(currentPrice) {
+			s.logger.Info("price outside of priceFloor and priceCeiling. no swap",
+				zap.Float64("currentPrice", currentPrice),
+				zap.Float64("priceFloor", s.swapTask.PriceFloor),
+				zap.Float64("priceCeiling", s.swapTask.PriceCeiling),
 			)
 			return nil
 		}
+
+		priceMultiplier = s.swapTask.PriceMultiplier(currentPrice)
+		if s.swapTask.PriceReference > 0 {
+			s.logger.Info("amount scaled by price band",
+				zap.Float64("currentPrice", currentPrice),
+				zap.Float64("priceReference", s.swapTask.PriceReference),
+				zap.Float64("priceMultiplier", priceMultiplier),
+			)
+		}
 	}
 
+	status := SwapStatus{
+		Pair:            s.swapTask.Pair,
+		Side:            s.swapTask.Side,
+		Price:           currentPrice,
+		PriceMultiplier: priceMultiplier,
+	}
+	amount, err := s.SliceAmount(ctx, fromTokenInfo, fromBalance, &status)
+	if err != nil {
+		s.logger.Warn("fail to compute swap amount", zap.Error(err))
+		return err
+	}
+
+	if s.hasBudget(s.swapTask.Side) {
+		amount, err = s.CapToBudget(fromTokenInfo, amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	amount, err = s.CapToFeeReserve(ctx, fromToken, amount, fromBalance)
+	if err != nil {
+		return err
+	}
+
+	if exitState != nil {
+		exitAmount := fromTokenInfo.FromFloat(s.swapTask.ExitAmount)
+		if exitState.Sold >= exitAmount {
+			s.logger.Info("exit amount sold, stopping swap "+fromTokenInfo.Symbol+" to "+toTokenInfo.Symbol,
+				zap.Uint64("exitAmount", exitAmount),
+			)
+			return ErrExitCompleted
+		}
+		if amount > exitAmount-exitState.Sold {
+			amount = exitAmount - exitState.Sold
+		}
+	}
+
+	if amount > fromBalance {
+		s.logger.Warn("not enough balance to swap "+fromTokenInfo.Symbol+" to "+toTokenInfo.Symbol,
+			zap.Uint64("swapAmount", amount),
+			zap.Uint64("currentBalance", fromBalance),
+		)
+		return ErrFromBalanceNotEnough
+	}
+
+	if amount == 0 {
+		s.logger.Info("swap amount is zero, skipping swap")
+		return nil
+	}
+
+	// A failed swap is saved in the store and tried again next interval
+	err = s.ExecuteSwap(ctx, amount, &status)
+	if stopsSwaps(err) {
+		return err
+	}
+	if err == nil && exitState != nil {
+		return s.RecordExitSold(exitState, status.Amount)
+	}
+
+	return nil
+}
+
+// notify logs a warning and sends it to the notifier when there is one.
+func (s *TokenSwapper) notify(message string, fields ...zap.Field) {
+	s.logger.Warn(message, fields...)
+	if s.notifier == nil {
+		return
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	text := fmt.Sprintf("[%s] %s %v", s.swapTask.ID, message, enc.Fields)
+	err := s.notifier.Notify(text)
+	if err != nil {
+		s.logger.Warn("fail to send notification", zap.Error(err))
+	}
+}
+
+// sideTokens returns the from and to tokens of the task pool for side.
+func (s *TokenSwapper) sideTokens(side SwapSide) (string, string) {
+	if side == SwapSide_Sell {
+		return s.swapTask.pool.ToToken, s.swapTask.pool.FromToken
+	}
+	return s.swapTask.pool.FromToken, s.swapTask.pool.ToToken
+}
+
+// ExecuteSwap quotes and swaps amount on the task pool in the direction of
+// status.Side and saves the status in the store. Retryable errors are tried
+// again with a new quote, up to SwapRetries times with a doubling backoff.
+func (s *TokenSwapper) ExecuteSwap(ctx context.Context, amount uint64, status *SwapStatus) error {
+	backoff := s.swapTask.retryBackoff
+	for {
+		var sig *solana.Signature
+		quote, err := s.Quote(ctx, status.Side, amount)
+		if err == nil {
+			err = s.acceptQuote(quote, status)
+		}
+		if errors.Is(err, errQuoteRejected) && len(status.Attempts) == 0 {
+			return err
+		}
+		if err == nil {
+			sig, err = s.swapQuote(ctx, quote, status)
+		} else {
+			status.Amount = amount
+		}
+		err = ClassifyError(err)
+		if sig != nil && err != nil {
+			err = s.checkSent(*sig, err)
+		}
+		status.Attempts = append(status.Attempts, newSwapAttempt(status, sig, err))
+
+		if err == nil || !IsRetryable(err) || len(status.Attempts) > s.swapTask.SwapRetries || ctx.Err() != nil {
+			return s.finishSwap(status, sig, err)
+		}
+		s.logger.Warn("swap attempt fail, retrying",
+			zap.Int("attempt", len(status.Attempts)),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return s.finishSwap(status, sig, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// finishSwap updates the circuit breaker with the result of the interval and
+// saves the status in the store.
+func (s *TokenSwapper) finishSwap(status *SwapStatus, sig *solana.Signature, err error) error {
+	s.updateBreaker(status, err)
+	return s.recordSwap(status, sig, err)
+}
+
+// acceptQuote returns errQuoteRejected when the quote is below the auction
+// price.
+func (s *TokenSwapper) acceptQuote(quote *SwapQuote, status *SwapStatus) error {
+	if s.swapTask.AuctionStartPrice <= 0 {
+		return nil
+	}
+	accepted, err := s.AuctionAccepts(quote, status)
+	if err != nil {
+		s.logger.Warn("fail to get auction price", zap.Error(err))
+		return err
+	}
+	if !accepted {
+		return errQuoteRejected
+	}
+	return nil
+}
+
+// Quote returns the expected output of swapping amount on the task pool.
+func (s *TokenSwapper) Quote(ctx context.Context, side SwapSide, amount uint64) (*SwapQuote, error) {
+	fromToken, toToken := s.sideTokens(side)
+	return s.raydiumSwap.Quote(ctx, &s.swapTask.pool.RaydiumPoolConfig, amount, fromToken, toToken)
+}
+
+// swapQuote swaps a quote from Quote, the signature is returned when the
+// transaction was sent even if it failed.
+func (s *TokenSwapper) swapQuote(ctx context.Context, quote *SwapQuote, status *SwapStatus) (*solana.Signature, error) {
+	fromToken, toToken := s.sideTokens(status.Side)
 	sig, err := s.raydiumSwap.Swap(
 		ctx,
 		&s.swapTask.pool.RaydiumPoolConfig,
-		amount,
+		quote,
 		fromToken,
-		fromAddress,
+		s.tokenAccounts[fromToken],
 		toToken,
-		toAddress,
+		s.tokenAccounts[toToken],
 	)
+	status.Amount = quote.InAmount
+	status.MinimumOut = quote.MinimumOutAmount
+	return sig, err
+}
 
-	status := SwapStatus{
-		Date:   time.Now().UTC().Format(time.UnixDate),
-		Pair:   s.swapTask.pair,
-		Side:   s.swapTask.side,
-		Amount: amount,
+func (s *TokenSwapper) recordSwap(status *SwapStatus, sig *solana.Signature, err error) error {
+	status.Task = s.swapTask.ID
+	status.Date = time.Now().UTC().Format(time.UnixDate)
+	if sig != nil {
+		status.TxID = sig.String()
 	}
 	if err != nil {
 		s.logger.Warn("swap fail", zap.Error(err))
-		status.ErrLogs = fmt.Sprintf("error: %v", err)
+		status.ErrLogs = ErrorLogs(err)
+		status.Unconfirmed = errors.Is(err, ErrUnconfirmed)
 	} else {
 		s.logger.Info("swap success", zap.String("txID", sig.String()))
-		status.TxID = sig.String()
+		s.recordFill(status, time.Now())
 	}
-	key := fmt.Sprintf("%s_%s", status.Pair, status.Date)
+	key := fmt.Sprintf("%s_%s", status.Task, status.Date)
 	s.store.Set(key, status)
 
-	return nil
+	return err
 }
 
 func NewTokenSwapper(cfg TokenSwapperConfig) (*TokenSwapper, error) {
-
-	store, err := store.OpenJSONStore(cfg.StorePath)
+	w, err := NewWallet(cfg)
 	if err != nil {
 		return nil, err
 	}
-
-	privateKey, err := solana.PrivateKeyFromBase58(cfg.PrivateKey)
-	if err != nil {
-		return nil, err
-	}
-
-	raydiumSwap := RaydiumSwap{
-		clientRPC: cfg.ClientRPC,
-		account:   privateKey,
-	}
-
-	l := TokenSwapper{
-		clientRPC:     cfg.ClientRPC,
-		RPCWs:         cfg.RPCWs,
-		store:         store,
-		logger:        cfg.Logger,
-		pools:         cfg.Pools,
-		tokens:        cfg.Tokens,
-		account:       privateKey,
-		raydiumSwap:   &raydiumSwap,
-		tokenBalances: map[string]uint64{},
-	}
-
-	return &l, nil
+	return w.NewTokenSwapper(), nil
 }
@@ -0,0 +1,53 @@
+package swap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var (
+	ErrNoTasks         = errors.New("task file has no tasks")
+	ErrTaskNoInterval  = errors.New("task has no interval")
+	ErrDuplicateTaskID = errors.New("task ids must be unique, set an ID on tasks using the same pair")
+)
+
+// LoadTasks reads a JSON array of tasks, unknown settings are an error. Task
+// IDs default to the pair.
+func LoadTasks(path string) ([]SwapTaskConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []SwapTaskConfig
+	// A misspelled setting would leave its safeguard off without a word
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&tasks)
+	if err != nil {
+		return nil, fmt.Errorf("parse task file: %w", err)
+	}
+	if len(tasks) == 0 {
+		return nil, ErrNoTasks
+	}
+
+	ids := map[string]bool{}
+	for i := range tasks {
+		if tasks[i].ID == "" {
+			tasks[i].ID = tasks[i].Pair
+		}
+		if tasks[i].Side == "" {
+			tasks[i].Side = SwapSide_Buy
+		}
+		if tasks[i].Interval == "" {
+			return nil, fmt.Errorf("%s: %w", tasks[i].ID, ErrTaskNoInterval)
+		}
+		if ids[tasks[i].ID] {
+			return nil, fmt.Errorf("%s: %w", tasks[i].ID, ErrDuplicateTaskID)
+		}
+		ids[tasks[i].ID] = true
+	}
+	return tasks, nil
+}
@@ -0,0 +1,103 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownMissedTickPolicy = errors.New("unknown missed tick policy")
+)
+
+// MissedTickPolicy tells what to do with the intervals missed while a slice
+// was still running or the process was down.
+type MissedTickPolicy string
+
+const (
+	// MissedTickPolicy_Skip drops the missed slices.
+	MissedTickPolicy_Skip MissedTickPolicy = "skip"
+	// MissedTickPolicy_Coalesce runs one slice sized for all the missed ones.
+	MissedTickPolicy_Coalesce MissedTickPolicy = "coalesce"
+	// MissedTickPolicy_CatchUp runs the missed slices one after the other.
+	MissedTickPolicy_CatchUp MissedTickPolicy = "catchup"
+)
+
+const (
+	defaultMaxMissedTicks = 10
+	// keep the last decisions only
+	tickDecisionsRetention = 100
+)
+
+// TickDecision records what a run did with the missed intervals.
+type TickDecision struct {
+	Timestamp int64
+	Policy    MissedTickPolicy
+	Missed    int
+	Slices    int
+	// Overlap is set when the tick came while the previous run was going on
+	Overlap bool `json:",omitempty"`
+}
+
+func ticksKey(taskID string) string {
+	return fmt.Sprintf("ticks_%s", taskID)
+}
+
+// missedTicks returns the number of whole intervals between the last run and
+// now that had no run.
+func (s *TokenSwapper) missedTicks(now time.Time) int {
+	if s.progress.LastRun == 0 || s.swapTask.interval == 0 {
+		return 0
+	}
+	elapsed := now.Sub(time.Unix(s.progress.LastRun, 0))
+	missed := int(elapsed/s.swapTask.interval) - 1
+	if missed < 0 {
+		return 0
+	}
+	return missed
+}
+
+// tickSlices returns how many slices to run for this tick and how many
+// slices the amount of each one stands for.
+func (s *TokenSwapper) tickSlices(missed int) (runs int, size int) {
+	if missed > s.swapTask.MaxMissedTicks {
+		missed = s.swapTask.MaxMissedTicks
+	}
+	switch s.swapTask.MissedTickPolicy {
+	case MissedTickPolicy_Coalesce:
+		return 1, missed + 1
+	case MissedTickPolicy_CatchUp:
+		return missed + 1, 1
+	}
+	return 1, 1
+}
+
+// recordTick saves a tick decision in the store.
+func (s *TokenSwapper) recordTick(decision TickDecision) {
+	// Nothing was missed, no need to fill the store
+	if decision.Missed == 0 && !decision.Overlap {
+		return
+	}
+	s.logger.Info("missed ticks",
+		zap.String("policy", string(decision.Policy)),
+		zap.Int("missed", decision.Missed),
+		zap.Int("slices", decision.Slices),
+		zap.Bool("overlap", decision.Overlap),
+	)
+
+	var decisions []TickDecision
+	_, err := s.store.Get(ticksKey(s.swapTask.ID), &decisions)
+	if err != nil {
+		s.logger.Warn("fail to load tick decisions", zap.Error(err))
+	}
+	decisions = append(decisions, decision)
+	if len(decisions) > tickDecisionsRetention {
+		decisions = decisions[len(decisions)-tickDecisionsRetention:]
+	}
+	err = s.store.Set(ticksKey(s.swapTask.ID), decisions)
+	if err != nil {
+		s.logger.Warn("fail to save tick decisions", zap.Error(err))
+	}
+}
@@ -0,0 +1,63 @@
+package swap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMissedTicks(t *testing.T) {
+	now := time.Unix(1_650_000_000, 0)
+	tests := []struct {
+		name     string
+		lastRun  time.Time
+		interval time.Duration
+		want     int
+	}{
+		{name: "first run", interval: time.Minute, want: 0},
+		{name: "on time", lastRun: now.Add(-time.Minute), interval: time.Minute, want: 0},
+		{name: "a bit late", lastRun: now.Add(-90 * time.Second), interval: time.Minute, want: 0},
+		{name: "two missed", lastRun: now.Add(-3 * time.Minute), interval: time.Minute, want: 2},
+		{name: "no interval", lastRun: now.Add(-time.Hour), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &TokenSwapper{}
+			s.swapTask.interval = tt.interval
+			if !tt.lastRun.IsZero() {
+				s.progress.LastRun = tt.lastRun.Unix()
+			}
+			if got := s.missedTicks(now); got != tt.want {
+				t.Errorf("missedTicks() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTickSlices(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   MissedTickPolicy
+		max      int
+		missed   int
+		wantRuns int
+		wantSize int
+	}{
+		{name: "skip", policy: MissedTickPolicy_Skip, max: 10, missed: 3, wantRuns: 1, wantSize: 1},
+		{name: "coalesce", policy: MissedTickPolicy_Coalesce, max: 10, missed: 3, wantRuns: 1, wantSize: 4},
+		{name: "catch up", policy: MissedTickPolicy_CatchUp, max: 10, missed: 3, wantRuns: 4, wantSize: 1},
+		{name: "coalesce capped", policy: MissedTickPolicy_Coalesce, max: 2, missed: 5, wantRuns: 1, wantSize: 3},
+		{name: "catch up capped", policy: MissedTickPolicy_CatchUp, max: 2, missed: 5, wantRuns: 3, wantSize: 1},
+		{name: "nothing missed", policy: MissedTickPolicy_CatchUp, max: 10, missed: 0, wantRuns: 1, wantSize: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &TokenSwapper{}
+			s.swapTask.MissedTickPolicy = tt.policy
+			s.swapTask.MaxMissedTicks = tt.max
+			runs, size := s.tickSlices(tt.missed)
+			if runs != tt.wantRuns || size != tt.wantSize {
+				t.Errorf("tickSlices(%d) = %d, %d, want %d, %d", tt.missed, runs, size, tt.wantRuns, tt.wantSize)
+			}
+		})
+	}
+}
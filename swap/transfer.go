@@ -0,0 +1,411 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	associatedtokenaccount "github.com/gagliardetto/solana-go/programs/associated-token-account"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/programs/token"
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownTransferPolicy = errors.New("unknown transfer policy, can be all, excess or chunk")
+	ErrInvalidTransferPolicy = errors.New("transfer policy excess needs transferRetain >= 0 and chunk a transferChunk > 0")
+	ErrInvalidTransferSplit  = errors.New("transfer splits need a positive weight and a different address each")
+)
+
+// transfers in one transaction, more are sent in several transactions to
+// stay below the transaction size limit
+const maxTransfersPerTx = 16
+
+// TransferPolicy tells how much of the balance is transferred once above
+// the transfer threshold.
+type TransferPolicy string
+
+const (
+	// TransferPolicy_All transfers the whole balance.
+	TransferPolicy_All TransferPolicy = "all"
+	// TransferPolicy_Excess transfers what is above TransferRetain.
+	TransferPolicy_Excess TransferPolicy = "excess"
+	// TransferPolicy_Chunk transfers TransferChunk at a time.
+	TransferPolicy_Chunk TransferPolicy = "chunk"
+)
+
+// TransferSplit is a destination of the transfers with its share, weights
+// are relative to the sum of the weights of all the splits.
+type TransferSplit struct {
+	Address string
+	Weight  float64
+}
+
+// ParseTransferSplit reads a split written as address:weight.
+func ParseTransferSplit(value string) (TransferSplit, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return TransferSplit{}, fmt.Errorf("%w: %s", ErrInvalidTransferSplit, value)
+	}
+	weight, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return TransferSplit{}, fmt.Errorf("%w: %s", ErrInvalidTransferSplit, value)
+	}
+	return TransferSplit{Address: parts[0], Weight: weight}, nil
+}
+
+type transferDestination struct {
+	address string
+	account solana.PublicKey
+	weight  float64
+}
+
+// Transfer is an amount for a destination token account.
+type Transfer struct {
+	Destination solana.PublicKey
+	Amount      uint64
+}
+
+// TransferStatus is saved in the store for every transfer.
+type TransferStatus struct {
+	Task        string
+	TxID        string
+	Date        string
+	Mint        string
+	Destination string
+	Amount      uint64
+	Policy      TransferPolicy `json:",omitempty"`
+	// Reason is threshold, sweep or cleanup
+	Reason  string `json:",omitempty"`
+	ErrLogs string `json:",omitempty"`
+}
+
+// transferKey has the index of the transfer in its split, the transfers of a
+// split are saved in the same second.
+func transferKey(taskID string, date string, index int) string {
+	return fmt.Sprintf("transfer_%s_%s_%d", taskID, date, index)
+}
+
+// validateTransfer checks the transfer and cleanup settings, before any
+// account is created for them.
+func (s *TokenSwapper) validateTransfer() error {
+	hasDestination := s.swapTask.TransferAddress != "" || len(s.swapTask.TransferSplits) > 0
+	if hasDestination != (s.swapTask.TransferThreshold > 0 || s.swapTask.TransferSweepAt != "") ||
+		(s.swapTask.TransferAddress != "" && len(s.swapTask.TransferSplits) > 0) {
+		return ErrInvalidTransfer
+	}
+	switch s.swapTask.TransferPolicy {
+	case TransferPolicy_All, "":
+		s.swapTask.TransferPolicy = TransferPolicy_All
+	case TransferPolicy_Excess:
+		if s.swapTask.TransferRetain < 0 {
+			return ErrInvalidTransferPolicy
+		}
+	case TransferPolicy_Chunk:
+		if s.swapTask.TransferChunk <= 0 {
+			return ErrInvalidTransferPolicy
+		}
+	default:
+		return ErrUnknownTransferPolicy
+	}
+	if s.swapTask.TransferSweepAt != "" {
+		_, err := time.Parse("15:04", s.swapTask.TransferSweepAt)
+		if err != nil {
+			return ErrInvalidSweepTime
+		}
+	}
+
+	addresses := map[string]bool{}
+	for _, split := range s.transferSplits() {
+		if split.Weight <= 0 || math.IsInf(split.Weight, 0) || math.IsNaN(split.Weight) || addresses[split.Address] {
+			return ErrInvalidTransferSplit
+		}
+		addresses[split.Address] = true
+	}
+	if s.swapTask.CleanupReturnAddress != "" {
+		addresses[s.swapTask.CleanupReturnAddress] = true
+	}
+	for address := range addresses {
+		_, err := solana.PublicKeyFromBase58(address)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidTransfer, address, err)
+		}
+	}
+	return nil
+}
+
+// transferSplits returns the transfer destinations with their weight, the
+// transfer address alone has all of it.
+func (s *TokenSwapper) transferSplits() []TransferSplit {
+	if s.swapTask.TransferAddress != "" {
+		return []TransferSplit{{Address: s.swapTask.TransferAddress, Weight: 1}}
+	}
+	return s.swapTask.TransferSplits
+}
+
+// initTransfer finds the token accounts the transfers and the cleanup go to,
+// the settings are checked by validateTransfer first.
+func (s *TokenSwapper) initTransfer(ctx context.Context) error {
+	if s.swapTask.CleanupReturnAddress != "" {
+		account, err := s.TransferTokenAccount(ctx, s.swapTask.fromToken, s.swapTask.CleanupReturnAddress)
+		if err != nil {
+			return err
+		}
+		s.swapTask.cleanupReturnAccount = account
+	}
+
+	s.swapTask.transferDestinations = nil
+	for _, split := range s.transferSplits() {
+		account, err := s.TransferTokenAccount(ctx, s.swapTask.toToken, split.Address)
+		if err != nil {
+			return err
+		}
+		s.swapTask.transferDestinations = append(s.swapTask.transferDestinations, transferDestination{
+			address: split.Address,
+			account: account,
+			weight:  split.Weight,
+		})
+	}
+	return nil
+}
+
+// TransferTokenAccount returns the associated token account of mint for a
+// transfer address, or the address itself for native SOL. When it does not exist it
+// is created, paid by the wallet, if TransferCreateAccount is set, otherwise
+// ErrNoTransferTokenAccount is returned as the transfer could not be
+// delivered.
+func (s *TokenSwapper) TransferTokenAccount(ctx context.Context, mint string, ownerAddress string) (solana.PublicKey, error) {
+	mintPK := solana.MustPublicKeyFromBase58(mint)
+	ownerPK, err := solana.PublicKeyFromBase58(ownerAddress)
+	if err != nil {
+		return solana.PublicKey{}, fmt.Errorf("%w: %s: %v", ErrInvalidTransfer, ownerAddress, err)
+	}
+	if mint == config.NativeSOL {
+		return ownerPK, nil
+	}
+	existingAccounts, missingAccounts, err := GetTokenAccountsFromMints(ctx, *s.clientRPC, ownerPK, mintPK)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	if len(missingAccounts) == 0 {
+		return existingAccounts[mint], nil
+	}
+
+	if !s.swapTask.TransferCreateAccount {
+		s.logger.Warn("transfer address do not have a token account",
+			zap.String("mint", mint),
+			zap.String("transferAddress", ownerAddress),
+		)
+		return solana.PublicKey{}, fmt.Errorf("%w: %s", ErrNoTransferTokenAccount, ownerAddress)
+	}
+	s.logger.Info("creating token account of transfer address",
+		zap.String("mint", mint),
+		zap.String("transferAddress", ownerAddress),
+	)
+	inst, err := associatedtokenaccount.NewCreateInstruction(
+		s.account.PublicKey(),
+		ownerPK,
+		mintPK,
+	).ValidateAndBuild()
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	sig, err := ExecuteInstructionsAndWaitConfirm(ctx, s.clientRPC, s.RPCWs, []solana.PrivateKey{s.account}, inst)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	s.logger.Info("transfer token account created", zap.String("txID", sig.String()))
+	return missingAccounts[mint], nil
+}
+
+// TransferAmount returns the amount of balance to transfer with the task
+// transfer policy, 0 when there is nothing to transfer. Native SOL keeps the
+// fee reserve in the wallet.
+func (s *TokenSwapper) TransferAmount(ctx context.Context, balance uint64) (uint64, error) {
+	available := balance
+	if s.swapTask.toToken == config.NativeSOL {
+		reserve, err := s.FeeReserve(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if balance <= reserve {
+			return 0, nil
+		}
+		available = balance - reserve
+	}
+
+	toTokenInfo := s.tokens[s.swapTask.toToken]
+	switch s.swapTask.TransferPolicy {
+	case TransferPolicy_Excess:
+		retain := toTokenInfo.FromFloat(s.swapTask.TransferRetain)
+		if balance <= retain {
+			return 0, nil
+		}
+		if balance-retain < available {
+			return balance - retain, nil
+		}
+	case TransferPolicy_Chunk:
+		chunk := toTokenInfo.FromFloat(s.swapTask.TransferChunk)
+		if available < chunk {
+			return 0, nil
+		}
+		return chunk, nil
+	}
+	return available, nil
+}
+
+// TransferToAddress transfers the to token to the transfer destinations once
+// its balance is above TransferThreshold. A failed transfer is tried again at
+// the next interval.
+func (s *TokenSwapper) TransferToAddress(ctx context.Context, balance uint64) error {
+	toToken := s.swapTask.toToken
+	toTokenInfo := s.tokens[toToken]
+	threshold := toTokenInfo.FromFloat(s.swapTask.TransferThreshold)
+	if len(s.swapTask.transferDestinations) == 0 || threshold == 0 || balance <= threshold {
+		return nil
+	}
+	s.logger.Info("transfer threshold reached, transfering "+toTokenInfo.Symbol,
+		zap.Float64("threshold", s.swapTask.TransferThreshold),
+	)
+	return s.transferOut(ctx, balance, "threshold")
+}
+
+// transferOut transfers the to token balance to the transfer destinations
+// with the transfer policy.
+func (s *TokenSwapper) transferOut(ctx context.Context, balance uint64, reason string) error {
+	toToken := s.swapTask.toToken
+	amount, err := s.TransferAmount(ctx, balance)
+	if err != nil {
+		s.logger.Warn("fail to get transfer amount", zap.Error(err))
+		return err
+	}
+	if amount == 0 {
+		s.logger.Info("nothing to transfer with the transfer policy",
+			zap.String("policy", string(s.swapTask.TransferPolicy)),
+		)
+		return nil
+	}
+
+	s.logger.Info("transfering "+s.tokens[toToken].Symbol,
+		zap.String("reason", reason),
+		zap.Uint64("transferAmount", amount),
+		zap.String("policy", string(s.swapTask.TransferPolicy)),
+		zap.Int("destinations", len(s.swapTask.transferDestinations)),
+	)
+	transfers := s.splitTransfer(amount)
+	for start := 0; start < len(transfers); start += maxTransfersPerTx {
+		end := start + maxTransfersPerTx
+		if end > len(transfers) {
+			end = len(transfers)
+		}
+		batch := transfers[start:end]
+		sig, err := s.TransferBalances(ctx, toToken, s.tokenAccounts[toToken], batch...)
+		for i, transfer := range batch {
+			s.recordTransfer(start+i, TransferStatus{
+				Mint:        toToken,
+				Destination: transfer.Destination.String(),
+				Amount:      transfer.Amount,
+				Policy:      s.swapTask.TransferPolicy,
+				Reason:      reason,
+			}, sig, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitTransfer splits amount between the transfer destinations by weight,
+// the rounding remainder goes to the first destination.
+func (s *TokenSwapper) splitTransfer(amount uint64) []Transfer {
+	totalWeight := 0.0
+	for _, dest := range s.swapTask.transferDestinations {
+		totalWeight += dest.weight
+	}
+	transfers := []Transfer{}
+	left := amount
+	for _, dest := range s.swapTask.transferDestinations {
+		share := uint64(float64(amount) * dest.weight / totalWeight)
+		if share > left {
+			share = left
+		}
+		left -= share
+		transfers = append(transfers, Transfer{Destination: dest.account, Amount: share})
+	}
+	if len(transfers) > 0 {
+		transfers[0].Amount += left
+	}
+	// Nothing to send for the destinations rounded to 0
+	kept := transfers[:0]
+	for _, transfer := range transfers {
+		if transfer.Amount > 0 {
+			kept = append(kept, transfer)
+		}
+	}
+	return kept
+}
+
+// transferInstruction moves amount of mint from the wallet sourceAddress to
+// destAddress, native SOL goes with the system program.
+func (s *TokenSwapper) transferInstruction(mint string, sourceAddress solana.PublicKey, amount uint64, destAddress solana.PublicKey) (solana.Instruction, error) {
+	if mint == config.NativeSOL {
+		return system.NewTransferInstruction(
+			amount,
+			s.account.PublicKey(),
+			destAddress,
+		).ValidateAndBuild()
+	}
+	return token.NewTransferInstruction(
+		amount,
+		sourceAddress,
+		destAddress,
+		s.account.PublicKey(),
+		[]solana.PublicKey{},
+	).ValidateAndBuild()
+}
+
+func (s *TokenSwapper) TransferBalance(ctx context.Context, mint string, sourceAddress solana.PublicKey, amount uint64, destAddress solana.PublicKey) (*solana.Signature, error) {
+	return s.TransferBalances(ctx, mint, sourceAddress, Transfer{Destination: destAddress, Amount: amount})
+}
+
+// TransferBalances sends the transfers of mint from sourceAddress in a
+// single transaction.
+func (s *TokenSwapper) TransferBalances(ctx context.Context, mint string, sourceAddress solana.PublicKey, transfers ...Transfer) (*solana.Signature, error) {
+	instrs := []solana.Instruction{}
+	for _, transfer := range transfers {
+		inst, err := s.transferInstruction(mint, sourceAddress, transfer.Amount, transfer.Destination)
+		if err != nil {
+			return nil, err
+		}
+		instrs = append(instrs, inst)
+	}
+	sig, err := ExecuteInstructionsAndWaitConfirm(ctx, s.clientRPC, s.RPCWs, []solana.PrivateKey{s.account}, instrs...)
+	if err != nil {
+		s.logger.Warn("transfer amount failed, will try again in next interval", zap.Error(err))
+		return sig, err
+	}
+	s.logger.Info("transfer balance success", zap.String("txID", sig.String()))
+	return sig, nil
+}
+
+func (s *TokenSwapper) recordTransfer(index int, status TransferStatus, sig *solana.Signature, err error) {
+	status.Task = s.swapTask.ID
+	status.Date = time.Now().UTC().Format(time.UnixDate)
+	if sig != nil {
+		status.TxID = sig.String()
+	}
+	if err != nil {
+		status.ErrLogs = ErrorLogs(err)
+	}
+	err = s.store.Set(transferKey(status.Task, status.Date, index), status)
+	if err != nil {
+		s.logger.Warn("fail to save transfer", zap.Error(err))
+	}
+}
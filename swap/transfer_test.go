@@ -0,0 +1,77 @@
+package swap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+func TestSplitTransfer(t *testing.T) {
+	a := solana.NewWallet().PublicKey()
+	b := solana.NewWallet().PublicKey()
+	c := solana.NewWallet().PublicKey()
+
+	tests := []struct {
+		name     string
+		accounts []solana.PublicKey
+		weights  []float64
+		amount   uint64
+		want     []Transfer
+	}{
+		{
+			name:     "single destination gets everything",
+			accounts: []solana.PublicKey{a},
+			weights:  []float64{1},
+			amount:   1000,
+			want:     []Transfer{{Destination: a, Amount: 1000}},
+		},
+		{
+			name:     "split by weight",
+			accounts: []solana.PublicKey{a, b},
+			weights:  []float64{70, 30},
+			amount:   1000,
+			want:     []Transfer{{Destination: a, Amount: 700}, {Destination: b, Amount: 300}},
+		},
+		{
+			name:     "rounding remainder goes to the first destination",
+			accounts: []solana.PublicKey{a, b, c},
+			weights:  []float64{1, 1, 1},
+			amount:   100,
+			want:     []Transfer{{Destination: a, Amount: 34}, {Destination: b, Amount: 33}, {Destination: c, Amount: 33}},
+		},
+		{
+			name:     "destinations rounded to zero are dropped",
+			accounts: []solana.PublicKey{a, b},
+			weights:  []float64{1000000, 1},
+			amount:   10,
+			want:     []Transfer{{Destination: a, Amount: 10}},
+		},
+		{
+			name:     "nothing to send",
+			accounts: []solana.PublicKey{a, b},
+			weights:  []float64{70, 30},
+			amount:   0,
+			want:     []Transfer{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &TokenSwapper{}
+			for i, account := range tt.accounts {
+				s.swapTask.transferDestinations = append(s.swapTask.transferDestinations, transferDestination{
+					address: account.String(),
+					account: account,
+					weight:  tt.weights[i],
+				})
+			}
+			got := s.splitTransfer(tt.amount)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitTransfer(%d) = %v, want %v", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
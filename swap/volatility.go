@@ -0,0 +1,111 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrVolatilityTooHigh = errors.New("volatility above maxVolatility, trading paused")
+)
+
+const defaultVolatilityWindow = "1h"
+
+// PriceSample is a pool spot price at a given time.
+type PriceSample struct {
+	Timestamp int64
+	Price     float64
+}
+
+// pricesKey is per task, tasks on the same pair keep samples for their own
+// window.
+func pricesKey(taskID string) string {
+	return fmt.Sprintf("prices_%s", taskID)
+}
+
+// RealizedVolatility returns the hourly volatility in percent from the log
+// returns between consecutive samples, normalized by the time between them
+// so it does not depend on the interval. It returns false when there are not
+// enough samples.
+func RealizedVolatility(samples []PriceSample) (float64, bool) {
+	variance := 0.0
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		dt := float64(cur.Timestamp - prev.Timestamp)
+		if dt <= 0 || prev.Price <= 0 || cur.Price <= 0 {
+			continue
+		}
+		r := math.Log(cur.Price / prev.Price)
+		variance += r * r / dt
+		n++
+	}
+	if n < 2 {
+		return 0, false
+	}
+	return math.Sqrt(variance/float64(n)*3600) * 100, true
+}
+
+// RecordPriceSample saves the pool price in the store and drops the samples
+// older than VolatilityWindow.
+func (s *TokenSwapper) RecordPriceSample(ctx context.Context, now time.Time) ([]PriceSample, error) {
+	price, err := s.GetPoolPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var samples []PriceSample
+	_, err = s.store.Get(pricesKey(s.swapTask.ID), &samples)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-s.swapTask.volatilityWindow).Unix()
+	kept := []PriceSample{}
+	for _, sample := range samples {
+		if sample.Timestamp >= cutoff {
+			kept = append(kept, sample)
+		}
+	}
+	kept = append(kept, PriceSample{
+		Timestamp: now.Unix(),
+		Price:     price,
+	})
+	err = s.store.Set(pricesKey(s.swapTask.ID), kept)
+	if err != nil {
+		return nil, err
+	}
+	return kept, nil
+}
+
+// VolatilityPaused samples the pool price and tells if trading must pause
+// because the realized volatility is above MaxVolatility.
+func (s *TokenSwapper) VolatilityPaused(ctx context.Context) (bool, error) {
+	samples, err := s.RecordPriceSample(ctx, time.Now())
+	if err != nil {
+		return false, err
+	}
+	volatility, ok := RealizedVolatility(samples)
+	if !ok {
+		return false, nil
+	}
+
+	paused := volatility > s.swapTask.MaxVolatility
+	if paused {
+		s.logger.Info("volatility too high, trading paused",
+			zap.Float64("volatility", volatility),
+			zap.Float64("maxVolatility", s.swapTask.MaxVolatility),
+		)
+	} else if s.progress.VolatilityPaused {
+		s.logger.Info("volatility back to normal, trading resumed",
+			zap.Float64("volatility", volatility),
+			zap.Float64("maxVolatility", s.swapTask.MaxVolatility),
+		)
+	}
+	s.progress.VolatilityPaused = paused
+	return paused, nil
+}
@@ -0,0 +1,192 @@
+package swap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidVolumeProfile  = errors.New("volume profile must have 24 non negative hour weights")
+	ErrVolumeProfileConflict = errors.New("volume profile comes from a file or from the pool snapshots, not both")
+)
+
+// keep one week of pool snapshots to build the hourly profile
+const snapshotsRetention = 7 * 24 * time.Hour
+
+// PoolSnapshot is the cumulative swap volume of the pool coin, in and out,
+// at a given time. The volume is a decimal string as the amm counters are
+// 128 bits.
+type PoolSnapshot struct {
+	Timestamp  int64
+	CoinVolume string `json:",omitempty"`
+}
+
+// VolumeProfile holds the relative trading activity for each UTC hour of the day.
+type VolumeProfile [24]float64
+
+// LoadVolumeProfile reads a JSON array of 24 hour-of-day weights, the first
+// weight is for 00:00-01:00 UTC.
+func LoadVolumeProfile(path string) (*VolumeProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var weights []float64
+	err = json.Unmarshal(data, &weights)
+	if err != nil {
+		return nil, fmt.Errorf("parse volume profile: %w", err)
+	}
+	if len(weights) != len(VolumeProfile{}) {
+		return nil, ErrInvalidVolumeProfile
+	}
+	var profile VolumeProfile
+	for i, w := range weights {
+		if w < 0 {
+			return nil, ErrInvalidVolumeProfile
+		}
+		profile[i] = w
+	}
+	return &profile, nil
+}
+
+// VolumeProfileFromSnapshots builds a profile from the pool coin volume
+// swapped between consecutive snapshots, spread over the hours they cover.
+// Hours without any observation get the average activity of the observed
+// ones. Returns nil if there is not enough data.
+func VolumeProfileFromSnapshots(snapshots []PoolSnapshot) *VolumeProfile {
+	var activity VolumeProfile
+	var covered [24]float64
+	for i := 1; i < len(snapshots); i++ {
+		prev, cur := snapshots[i-1], snapshots[i]
+		if cur.Timestamp <= prev.Timestamp {
+			continue
+		}
+		prevVolume, ok := new(big.Int).SetString(prev.CoinVolume, 10)
+		if !ok {
+			continue
+		}
+		curVolume, ok := new(big.Int).SetString(cur.CoinVolume, 10)
+		// The counters only grow, skip a reset amm
+		if !ok || curVolume.Cmp(prevVolume) < 0 {
+			continue
+		}
+		volume, _ := new(big.Float).SetInt(new(big.Int).Sub(curVolume, prevVolume)).Float64()
+		duration := float64(cur.Timestamp - prev.Timestamp)
+
+		// Spread the volume over each hour of the period
+		for start := prev.Timestamp; start < cur.Timestamp; {
+			end := (start/3600 + 1) * 3600
+			if end > cur.Timestamp {
+				end = cur.Timestamp
+			}
+			hour := time.Unix(start, 0).UTC().Hour()
+			activity[hour] += volume * float64(end-start) / duration
+			covered[hour] += float64(end-start) / 3600
+			start = end
+		}
+	}
+
+	var profile VolumeProfile
+	observed := 0
+	total := 0.0
+	for h := range profile {
+		if covered[h] == 0 {
+			continue
+		}
+		profile[h] = activity[h] / covered[h]
+		total += profile[h]
+		observed++
+	}
+	if observed == 0 {
+		return nil
+	}
+	for h := range profile {
+		if covered[h] == 0 {
+			profile[h] = total / float64(observed)
+		}
+	}
+	return &profile
+}
+
+// Weight returns the slice multiplier for the hour of t. Weights are
+// normalized to an average of 1 so a full day of slices still adds up to the
+// configured amount times the number of slices.
+func (p *VolumeProfile) Weight(t time.Time) float64 {
+	total := 0.0
+	for _, w := range p {
+		total += w
+	}
+	if total == 0 {
+		return 1
+	}
+	return p[t.UTC().Hour()] * float64(len(p)) / total
+}
+
+func snapshotsKey(pair string) string {
+	return fmt.Sprintf("snapshots_%s", pair)
+}
+
+// GetPoolSnapshots returns the recorded snapshots of the task pool, oldest first.
+func (s *TokenSwapper) GetPoolSnapshots() ([]PoolSnapshot, error) {
+	var snapshots []PoolSnapshot
+	_, err := s.store.Get(snapshotsKey(s.swapTask.Pair), &snapshots)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// RecordPoolSnapshot saves the current pool coin swap volume and drops the
+// snapshots older than the retention period.
+func (s *TokenSwapper) RecordPoolSnapshot(ctx context.Context, now time.Time) error {
+	amm, err := s.raydiumSwap.GetAmmInfo(ctx, &s.swapTask.pool.RaydiumPoolConfig)
+	if err != nil {
+		return err
+	}
+	volume, _ := amm.SwapVolume(amm.CoinMint.String())
+	snapshots, err := s.GetPoolSnapshots()
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-snapshotsRetention).Unix()
+	kept := []PoolSnapshot{}
+	for _, snap := range snapshots {
+		if snap.Timestamp >= cutoff {
+			kept = append(kept, snap)
+		}
+	}
+	kept = append(kept, PoolSnapshot{
+		Timestamp:  now.Unix(),
+		CoinVolume: volume.String(),
+	})
+	return s.store.Set(snapshotsKey(s.swapTask.Pair), kept)
+}
+
+// VolumeWeight returns the slice multiplier from the task volume profile, 1
+// when no profile is configured or there is not enough recorded data yet.
+func (s *TokenSwapper) VolumeWeight(ctx context.Context, now time.Time) float64 {
+	profile := s.swapTask.volumeProfile
+	if s.swapTask.VolumeProfileSnapshots {
+		err := s.RecordPoolSnapshot(ctx, now)
+		if err != nil {
+			s.logger.Warn("fail to record pool snapshot", zap.Error(err))
+		}
+		snapshots, err := s.GetPoolSnapshots()
+		if err != nil {
+			s.logger.Warn("fail to load pool snapshots", zap.Error(err))
+		}
+		profile = VolumeProfileFromSnapshots(snapshots)
+	}
+	if profile == nil {
+		return 1
+	}
+	return profile.Weight(now)
+}
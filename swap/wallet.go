@@ -0,0 +1,102 @@
+package swap
+
+import (
+	"sync"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gopartyparrot/goparrot-twap/config"
+	"github.com/gopartyparrot/goparrot-twap/notify"
+	"github.com/gopartyparrot/goparrot-twap/store"
+	"go.uber.org/zap"
+)
+
+// Wallet is shared by all the tasks of the process: one RPC client, one
+// private key, one store and one balance cache.
+type Wallet struct {
+	clientRPC   *rpc.Client
+	RPCWs       string
+	store       *store.JSONStore
+	account     solana.PrivateKey
+	logger      *zap.Logger
+	notifier    notify.Notifier
+	raydiumSwap *RaydiumSwap
+	tokens      map[string]config.TokenInfo
+	pools       map[string]config.PoolConfig
+	resetFailed bool
+
+	// swapMu is held by a task from reading the balances until its swaps are
+	// confirmed, so tasks never spend the same balance twice. The holder
+	// bounds its RPC calls and confirmation waits with a timeout so one
+	// stuck transaction does not freeze the other tasks.
+	swapMu sync.Mutex
+
+	balancesMu    sync.Mutex
+	tokenBalances map[string]uint64
+
+	// tasks created with the wallet, they must all be created before the
+	// scheduler starts
+	swappers []*TokenSwapper
+}
+
+func NewWallet(cfg TokenSwapperConfig) (*Wallet, error) {
+
+	store, err := store.OpenJSONStore(cfg.StorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := solana.PrivateKeyFromBase58(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	raydiumSwap := RaydiumSwap{
+		clientRPC: cfg.ClientRPC,
+		RPCWs:     cfg.RPCWs,
+		account:   privateKey,
+	}
+
+	w := Wallet{
+		clientRPC:     cfg.ClientRPC,
+		RPCWs:         cfg.RPCWs,
+		store:         store,
+		logger:        cfg.Logger,
+		notifier:      cfg.Notifier,
+		pools:         cfg.Pools,
+		tokens:        cfg.Tokens,
+		account:       privateKey,
+		raydiumSwap:   &raydiumSwap,
+		tokenBalances: map[string]uint64{},
+		resetFailed:   cfg.ResetFailed,
+	}
+
+	return &w, nil
+}
+
+// NewTokenSwapper creates a task using the wallet, it must be Init before use.
+func (w *Wallet) NewTokenSwapper() *TokenSwapper {
+	s := &TokenSwapper{
+		Wallet:    w,
+		logger:    w.logger,
+		state:     TaskState_Running,
+		done:      make(chan struct{}),
+		sliceSize: 1,
+	}
+	w.swappers = append(w.swappers, s)
+	return s
+}
+
+func (w *Wallet) balance(account solana.PublicKey) uint64 {
+	w.balancesMu.Lock()
+	defer w.balancesMu.Unlock()
+	return w.tokenBalances[account.String()]
+}
+
+func (w *Wallet) setBalances(balances map[string]uint64) {
+	w.balancesMu.Lock()
+	defer w.balancesMu.Unlock()
+	for address, amount := range balances {
+		w.tokenBalances[address] = amount
+	}
+}